@@ -6,10 +6,13 @@ import (
 	"errors"
 	"io"
 	"log"
+	"math"
 	"minim/model"
+	"minim/notify"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type Message struct {
@@ -20,6 +23,11 @@ type Response struct {
 	Status  string `json:"status"`
 	Message string `json:"message"`
 	Data    any    `json:"data,omitempty"`
+	Meta    any    `json:"meta,omitempty"`
+}
+
+type GraphDataMeta struct {
+	Unit string `json:"unit"`
 }
 
 type StatRequest struct {
@@ -34,16 +42,32 @@ func isNumber(s string) bool {
 }
 
 func writeResponse(w http.ResponseWriter, err error, data any) {
+	writeStatusResponse(w, http.StatusBadRequest, err, data)
+}
+
+func writeStatusResponse(w http.ResponseWriter, errStatus int, err error, data any) {
+	writeFullResponse(w, errStatus, err, data, nil)
+}
+
+// writeMetaResponse adds information about the data that does not fit into
+// the data field itself, e.g. the unit of a series
+func writeMetaResponse(w http.ResponseWriter, err error, data any, meta any) {
+	writeFullResponse(w, http.StatusBadRequest, err, data, meta)
+}
+
+func writeFullResponse(w http.ResponseWriter, errStatus int, err error, data any, meta any) {
 	w.Header().Set("Content-Type", "application/json")
 	response := Response{
 		Status:  "OK",
 		Message: "Success",
 		Data:    data,
+		Meta:    meta,
 	}
 
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(errStatus)
 		log.Printf("Error: %v", err)
+		model.CountDbError(err)
 
 		response = Response{
 			Status:  "ERROR",
@@ -57,21 +81,6 @@ func writeResponse(w http.ResponseWriter, err error, data any) {
 	}
 }
 
-func Middleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
-
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-
-		next(w, r)
-	}
-}
-
 func HandleGraphs(w http.ResponseWriter, r *http.Request) {
 
 	path := r.URL.Path
@@ -132,10 +141,38 @@ func HandleGraphs(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if parts[3] == "copy" || parts[3] == "move" {
+			if r.Method != http.MethodPost {
+				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+
+			var target model.GraphTarget
+			if err := json.NewDecoder(r.Body).Decode(&target); err != nil {
+				writeResponse(w, err, nil)
+				return
+			}
+
+			var graph model.Graph
+			if parts[3] == "copy" {
+				graph, err = model.CopyGraph(int64(graphId), target)
+			} else {
+				graph, err = model.MoveGraph(int64(graphId), target)
+			}
+			writeResponse(w, err, graph)
+			return
+		}
+
 		switch r.Method {
 		case http.MethodGet:
+			graph, err := model.GetGraph(int64(graphId))
+			if err != nil {
+				writeResponse(w, err, nil)
+				return
+			}
+
 			graphData, err := model.GetGraphData(int64(graphId))
-			writeResponse(w, err, graphData)
+			writeMetaResponse(w, err, graphData, GraphDataMeta{Unit: graph.Unit})
 
 		default:
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -180,6 +217,21 @@ func HandleDashboard(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		}
 
+	} else if len(parts) == 3 && parts[2] == "import" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var definition model.DashboardDefinition
+		if err := json.NewDecoder(r.Body).Decode(&definition); err != nil {
+			writeResponse(w, err, nil)
+			return
+		}
+
+		dash, err := model.ImportDashboard(definition, r.URL.Query().Get("replace") == "1")
+		writeResponse(w, err, dash)
+
 	} else if len(parts) > 2 {
 		dashboardId, err := strconv.Atoi(parts[2])
 		if err != nil {
@@ -214,6 +266,32 @@ func HandleDashboard(w http.ResponseWriter, r *http.Request) {
 				http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			}
 
+		} else if len(parts) == 4 && parts[3] == "export" && r.Method == http.MethodGet {
+			definition, err := model.ExportDashboard(int64(dashboardId))
+			writeResponse(w, err, definition)
+
+		} else if len(parts) == 4 && parts[3] == "clone" && r.Method == http.MethodPost {
+			var clone model.DashboardClone
+			if r.ContentLength != 0 {
+				if err := json.NewDecoder(r.Body).Decode(&clone); err != nil {
+					writeResponse(w, err, nil)
+					return
+				}
+			}
+
+			dash, err := model.CloneDashboard(int64(dashboardId), clone)
+			writeResponse(w, err, dash)
+
+		} else if len(parts) == 4 && parts[3] == "layout" && r.Method == http.MethodPatch {
+			var layout model.DashboardLayout
+			if err := json.NewDecoder(r.Body).Decode(&layout); err != nil {
+				writeResponse(w, err, nil)
+				return
+			}
+
+			dash, err := model.UpdateDashboardLayout(int64(dashboardId), layout)
+			writeResponse(w, err, dash)
+
 		} else {
 			writeResponse(w, errors.New("Invalid request"), nil)
 		}
@@ -235,8 +313,200 @@ func HandleConfig(w http.ResponseWriter, r *http.Request) {
 	writeResponse(w, nil, value)
 }
 
-func HandleEventDefsApi(w http.ResponseWriter, r *http.Request) {
-	writeResponse(w, nil, model.GetEventDefs())
+func parseEventDefQuery(r *http.Request) (model.EventDefQuery, error) {
+	query := r.URL.Query()
+
+	eventDefQuery := model.EventDefQuery{
+		Sort: query.Get("sort"),
+		Desc: query.Get("order") == "desc",
+		Tag:  query.Get("tag"),
+	}
+
+	if inactive := query.Get("inactive"); inactive != "" {
+		duration, err := model.ParseDuration(inactive)
+		if err != nil {
+			return eventDefQuery, err
+		}
+
+		eventDefQuery.InactiveFor = duration
+	}
+
+	return eventDefQuery, nil
+}
+
+// parseSeriesQuery reads the period and length query parameters of the
+// value and dimension series, 24 hourly buckets by default
+func parseSeriesQuery(r *http.Request) (string, int64, error) {
+	query := r.URL.Query()
+
+	period := strings.ToUpper(query.Get("period"))
+	if period == "" {
+		period = "HOURLY"
+	}
+
+	length := int64(24)
+	if value := query.Get("length"); value != "" {
+		var err error
+		length, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return period, length, errors.New("Invalid length")
+		}
+	}
+
+	return period, length, nil
+}
+
+func HandleEventDefs(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+
+	trimmedPath := strings.Trim(path, "/")
+	parts := strings.Split(trimmedPath, "/")
+
+	if len(parts) == 2 {
+		switch r.Method {
+		case http.MethodGet:
+			eventDefQuery, err := parseEventDefQuery(r)
+			if err != nil {
+				writeResponse(w, err, nil)
+				return
+			}
+
+			eventDefs, err := model.GetEventDefs(eventDefQuery)
+			writeResponse(w, err, eventDefs)
+
+		case http.MethodPost:
+			var postData Message
+			if err := json.NewDecoder(r.Body).Decode(&postData); err != nil {
+				writeResponse(w, err, nil)
+				return
+			}
+
+			eventDef, err := model.CreateEventDef(postData.Event)
+			writeResponse(w, err, eventDef)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+	} else if parts[2] == "pending" {
+		if len(parts) == 3 && r.Method == http.MethodGet {
+			pendingEvents, err := model.GetPendingEvents()
+			writeResponse(w, err, pendingEvents)
+
+		} else if len(parts) == 4 && r.Method == http.MethodDelete {
+			err := model.RejectPendingEvent(parts[3])
+			writeResponse(w, err, nil)
+
+		} else if len(parts) == 5 && parts[4] == "approve" && r.Method == http.MethodPost {
+			eventDef, err := model.ApprovePendingEvent(parts[3])
+			writeResponse(w, err, eventDef)
+
+		} else {
+			writeResponse(w, errors.New("Invalid request"), nil)
+		}
+
+	} else if len(parts) == 3 {
+		switch r.Method {
+		case http.MethodGet:
+			eventDef, err := model.GetEventDef(parts[2])
+			writeResponse(w, err, eventDef)
+
+		case http.MethodPatch:
+			var patchData model.EventUpdate
+			if err := json.NewDecoder(r.Body).Decode(&patchData); err != nil {
+				writeResponse(w, err, nil)
+				return
+			}
+
+			eventDef, err := model.UpdateEventDef(parts[2], patchData)
+			writeResponse(w, err, eventDef)
+
+		case http.MethodDelete:
+			err := model.DeleteEvent(parts[2])
+			writeResponse(w, err, nil)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+	} else if len(parts) >= 4 && (parts[3] == "values" || parts[3] == "dimensions") && r.Method == http.MethodGet {
+		_, err := model.GetEventDef(parts[2])
+		if err != nil {
+			writeResponse(w, err, nil)
+			return
+		}
+
+		if len(parts) == 4 && parts[3] == "dimensions" {
+			dimensions, err := model.GetDimensions(parts[2])
+			writeResponse(w, err, dimensions)
+			return
+		}
+
+		period, length, err := parseSeriesQuery(r)
+		if err != nil {
+			writeResponse(w, err, nil)
+			return
+		}
+
+		if len(parts) == 4 {
+			valueStats, err := model.GetEventValues(parts[2], period, length)
+			writeResponse(w, err, valueStats)
+
+		} else if len(parts) == 5 && parts[3] == "dimensions" {
+			series, err := model.GetDimensionValues(parts[2], parts[4], period, length)
+			writeResponse(w, err, series)
+
+		} else {
+			writeResponse(w, errors.New("Invalid request"), nil)
+		}
+
+	} else if len(parts) == 4 && r.Method == http.MethodPost {
+		switch parts[3] {
+		case "rename":
+			var postData model.EventRename
+			if err := json.NewDecoder(r.Body).Decode(&postData); err != nil {
+				writeResponse(w, err, nil)
+				return
+			}
+
+			eventDef, err := model.RenameEvent(parts[2], postData.Name)
+			writeResponse(w, err, eventDef)
+
+		case "merge":
+			var postData model.EventMerge
+			if err := json.NewDecoder(r.Body).Decode(&postData); err != nil {
+				writeResponse(w, err, nil)
+				return
+			}
+
+			eventDef, err := model.MergeEvents(parts[2], postData.Into)
+			writeResponse(w, err, eventDef)
+
+		default:
+			writeResponse(w, errors.New("Invalid request"), nil)
+		}
+
+	} else {
+		writeResponse(w, errors.New("Invalid request"), nil)
+	}
+}
+
+// setRetryAfter tells rate limited clients how long to wait, in whole
+// seconds of at least one
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(max(retryAfter, 1)))
+}
+
+func writeIngestError(w http.ResponseWriter, err error) {
+	var rateLimitErr *RateLimitError
+	if errors.As(err, &rateLimitErr) {
+		setRetryAfter(w, rateLimitErr.RetryAfter)
+		writeStatusResponse(w, http.StatusTooManyRequests, err, nil)
+		return
+	}
+
+	writeResponse(w, err, nil)
 }
 
 func HandleEvent(w http.ResponseWriter, r *http.Request) {
@@ -248,11 +518,17 @@ func HandleEvent(w http.ResponseWriter, r *http.Request) {
 		log.Println(err)
 	}
 
-	event := t.Event
-	model.InitEvent(event)
-	model.SubmitDailyEvent(event)
-	model.SubmitHourlyEvent(event)
-	model.SubmitMinuteEvent(event)
+	err = Ingest(clientKey(r), t.Event)
+	if errors.Is(err, ErrEventPending) {
+		w.WriteHeader(http.StatusAccepted)
+		io.WriteString(w, "PENDING")
+		return
+	}
+
+	if err != nil {
+		writeIngestError(w, err)
+		return
+	}
 
 	io.WriteString(w, "OK")
 }
@@ -305,9 +581,327 @@ func HandleStat(w http.ResponseWriter, r *http.Request) {
 
 }
 
+func HandleAlerts(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+
+	trimmedPath := strings.Trim(path, "/")
+	parts := strings.Split(trimmedPath, "/")
+
+	if len(parts) == 2 {
+		switch r.Method {
+		case http.MethodGet:
+			alerts, err := model.GetAlerts()
+			writeResponse(w, err, alerts)
+
+		case http.MethodPost:
+			var postData model.AlertCreate
+			if err := json.NewDecoder(r.Body).Decode(&postData); err != nil {
+				writeResponse(w, err, nil)
+				return
+			}
+
+			alert, err := model.CreateAlert(postData)
+			writeResponse(w, err, alert)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+		return
+	}
+
+	alertId, err := strconv.Atoi(parts[2])
+	if err != nil {
+		writeResponse(w, err, nil)
+		return
+	}
+
+	if len(parts) == 3 {
+		switch r.Method {
+		case http.MethodGet:
+			alert, err := model.GetAlert(int64(alertId))
+			writeResponse(w, err, alert)
+
+		case http.MethodPatch:
+			var patchData model.AlertUpdate
+			if err := json.NewDecoder(r.Body).Decode(&patchData); err != nil {
+				writeResponse(w, err, nil)
+				return
+			}
+
+			alert, err := model.UpdateAlert(int64(alertId), patchData)
+			writeResponse(w, err, alert)
+
+		case http.MethodDelete:
+			err = model.DeleteAlert(int64(alertId))
+			writeResponse(w, err, nil)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+	} else if len(parts) == 4 && parts[3] == "history" && r.Method == http.MethodGet {
+		history, err := model.GetAlertHistory(int64(alertId))
+		writeResponse(w, err, history)
+
+	} else if len(parts) == 4 && parts[3] == "evaluations" && r.Method == http.MethodGet {
+		evaluations, err := model.GetAlertEvaluations(int64(alertId))
+		writeResponse(w, err, evaluations)
+
+	} else {
+		writeResponse(w, errors.New("Invalid request"), nil)
+	}
+}
+
+func HandleChannels(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+
+	trimmedPath := strings.Trim(path, "/")
+	parts := strings.Split(trimmedPath, "/")
+
+	if len(parts) == 2 {
+		switch r.Method {
+		case http.MethodGet:
+			channels, err := model.GetChannels()
+			writeResponse(w, err, channels)
+
+		case http.MethodPost:
+			var postData model.ChannelCreate
+			if err := json.NewDecoder(r.Body).Decode(&postData); err != nil {
+				writeResponse(w, err, nil)
+				return
+			}
+
+			channel, err := model.CreateChannel(postData)
+			writeResponse(w, err, channel)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+		return
+	}
+
+	channelId, err := strconv.Atoi(parts[2])
+	if err != nil {
+		writeResponse(w, err, nil)
+		return
+	}
+
+	if len(parts) == 3 {
+		switch r.Method {
+		case http.MethodGet:
+			channel, err := model.GetChannel(int64(channelId))
+			writeResponse(w, err, channel)
+
+		case http.MethodPatch:
+			var patchData model.ChannelUpdate
+			if err := json.NewDecoder(r.Body).Decode(&patchData); err != nil {
+				writeResponse(w, err, nil)
+				return
+			}
+
+			channel, err := model.UpdateChannel(int64(channelId), patchData)
+			writeResponse(w, err, channel)
+
+		case http.MethodDelete:
+			err = model.DeleteChannel(int64(channelId))
+			writeResponse(w, err, nil)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+	} else if len(parts) == 4 && parts[3] == "deliveries" && r.Method == http.MethodGet {
+		deliveries, err := model.GetDeliveries(int64(channelId))
+		writeResponse(w, err, deliveries)
+
+	} else if len(parts) == 4 && parts[3] == "test" && r.Method == http.MethodPost {
+		channel, err := model.GetChannel(int64(channelId))
+		if err != nil {
+			writeResponse(w, err, nil)
+			return
+		}
+
+		err = notify.Test(channel)
+		writeResponse(w, err, nil)
+
+	} else {
+		writeResponse(w, errors.New("Invalid request"), nil)
+	}
+}
+
+func HandleSilences(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+
+	trimmedPath := strings.Trim(path, "/")
+	parts := strings.Split(trimmedPath, "/")
+
+	if len(parts) == 2 {
+		switch r.Method {
+		case http.MethodGet:
+			silences, err := model.GetSilences()
+			writeResponse(w, err, silences)
+
+		case http.MethodPost:
+			var postData model.SilenceCreate
+			if err := json.NewDecoder(r.Body).Decode(&postData); err != nil {
+				writeResponse(w, err, nil)
+				return
+			}
+
+			silence, err := model.CreateSilence(postData)
+			writeResponse(w, err, silence)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+		return
+	}
+
+	silenceId, err := strconv.Atoi(parts[2])
+	if err != nil {
+		writeResponse(w, err, nil)
+		return
+	}
+
+	if len(parts) == 3 {
+		switch r.Method {
+		case http.MethodGet:
+			silence, err := model.GetSilence(int64(silenceId))
+			writeResponse(w, err, silence)
+
+		case http.MethodDelete:
+			err = model.DeleteSilence(int64(silenceId))
+			writeResponse(w, err, nil)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+		return
+	}
+
+	writeResponse(w, errors.New("Invalid request"), nil)
+}
+
+func HandleReports(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+
+	trimmedPath := strings.Trim(path, "/")
+	parts := strings.Split(trimmedPath, "/")
+
+	if len(parts) == 2 {
+		switch r.Method {
+		case http.MethodGet:
+			reports, err := model.GetReports()
+			writeResponse(w, err, reports)
+
+		case http.MethodPost:
+			var postData model.ReportCreate
+			if err := json.NewDecoder(r.Body).Decode(&postData); err != nil {
+				writeResponse(w, err, nil)
+				return
+			}
+
+			report, err := model.CreateReport(postData)
+			writeResponse(w, err, report)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+		return
+	}
+
+	reportId, err := strconv.Atoi(parts[2])
+	if err != nil {
+		writeResponse(w, err, nil)
+		return
+	}
+
+	if len(parts) == 3 {
+		switch r.Method {
+		case http.MethodGet:
+			report, err := model.GetReport(int64(reportId))
+			writeResponse(w, err, report)
+
+		case http.MethodPatch:
+			var patchData model.ReportUpdate
+			if err := json.NewDecoder(r.Body).Decode(&patchData); err != nil {
+				writeResponse(w, err, nil)
+				return
+			}
+
+			report, err := model.UpdateReport(int64(reportId), patchData)
+			writeResponse(w, err, report)
+
+		case http.MethodDelete:
+			err = model.DeleteReport(int64(reportId))
+			writeResponse(w, err, nil)
+
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+
+		return
+	}
+
+	report, err := model.GetReport(int64(reportId))
+	if err != nil {
+		writeResponse(w, err, nil)
+		return
+	}
+
+	if len(parts) == 4 && parts[3] == "preview" && r.Method == http.MethodGet {
+		rendered, err := notify.RenderReport(report)
+		writeResponse(w, err, rendered)
+
+	} else if len(parts) == 4 && parts[3] == "send" && r.Method == http.MethodPost {
+		err = notify.SendReport(report)
+		writeResponse(w, err, nil)
+
+	} else {
+		writeResponse(w, errors.New("Invalid request"), nil)
+	}
+}
+
+func HandleStatus(w http.ResponseWriter, r *http.Request) {
+	writeResponse(w, nil, GetRejectStats())
+}
+
 func HandleAPIBase(w http.ResponseWriter, r *http.Request) {
 	writeResponse(w, nil, nil)
 }
 
 func HandleTest(w http.ResponseWriter, r *http.Request) {
 }
+
+// HandleAdmin serves the maintenance routes: GET /api/admin/backup streams a
+// consistent snapshot of the database, GET /api/admin/export dumps the data
+// and POST /api/admin/import restores a dump
+func HandleAdmin(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case parts[2] == "backup" && r.Method == http.MethodGet:
+		handleBackup(w)
+
+	case parts[2] == "export" && r.Method == http.MethodGet:
+		handleExport(w, r)
+
+	case parts[2] == "import" && r.Method == http.MethodPost:
+		handleImport(w, r)
+
+	case parts[2] == "backup" || parts[2] == "export" || parts[2] == "import":
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
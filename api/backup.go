@@ -0,0 +1,57 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"log"
+	"minim/model"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// handleBackup streams a snapshot of the database. It holds every secret of
+// the config, so it is only served once BACKUP_API is set to 1.
+func handleBackup(w http.ResponseWriter) {
+	enabled, _ := model.GetConfigValue("BACKUP_API")
+	if enabled != "1" {
+		writeStatusResponse(w, http.StatusForbidden, errors.New("Backup downloads are disabled, set BACKUP_API to 1"), nil)
+		return
+	}
+
+	tmpDir, err := os.MkdirTemp("", "minim-backup")
+	if err != nil {
+		writeFullResponse(w, http.StatusInternalServerError, err, nil, nil)
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	name := "minim-" + time.Now().Format("20060102-150405") + ".db"
+	path := filepath.Join(tmpDir, name)
+
+	err = model.BackupDatabase(path)
+	if err != nil {
+		writeFullResponse(w, http.StatusInternalServerError, err, nil, nil)
+		return
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		writeFullResponse(w, http.StatusInternalServerError, err, nil, nil)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", "application/vnd.sqlite3")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	if info, err := file.Stat(); err == nil {
+		w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	}
+
+	_, err = io.Copy(w, file)
+	if err != nil {
+		log.Println("Unable to send the backup:", err)
+	}
+}
@@ -0,0 +1,156 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"minim/model"
+	"net/http"
+	"time"
+)
+
+// Largest number of events accepted in one batch
+const maxBatchSize = 100
+
+// Largest count of a single batch event
+const maxBatchEventCount = 1000
+
+// Largest batch request body accepted
+const maxBatchBodySize = 1 << 20
+
+// BatchEvent is one event of a batch, Count defaults to 1 and Value is
+// aggregated like a gauge when given. Time is the unix time the event
+// happened at, so spooled events land in their original buckets.
+type BatchEvent struct {
+	Event      string            `json:"event"`
+	Count      *int64            `json:"count"`
+	Value      *float64          `json:"value"`
+	Time       *int64            `json:"time"`
+	Dimensions map[string]string `json:"dimensions"`
+}
+
+// BatchMessage is a batch of events. Token is the ingest token for clients
+// that cannot set the Authorization header, such as the tracking script.
+type BatchMessage struct {
+	Token  string       `json:"token,omitempty"`
+	Events []BatchEvent `json:"events"`
+}
+
+type BatchError struct {
+	Index   int    `json:"index"`
+	Event   string `json:"event"`
+	Message string `json:"message"`
+}
+
+// BatchResult counts the outcome of a batch. Rejected events are refused
+// for their content, RateLimited lists the indexes of the events that were
+// not recorded because of the rate limits and can be sent again later.
+type BatchResult struct {
+	Accepted    int          `json:"accepted"`
+	Pending     int          `json:"pending"`
+	Rejected    int          `json:"rejected"`
+	RateLimited []int        `json:"rateLimited,omitempty"`
+	Errors      []BatchError `json:"errors"`
+}
+
+// HandleEventBatch records several events in one request. The body is read
+// as JSON whatever its content type so navigator.sendBeacon can send it as
+// text/plain without a CORS preflight.
+func HandleEventBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var batch BatchMessage
+	err := json.NewDecoder(io.LimitReader(r.Body, maxBatchBodySize)).Decode(&batch)
+	if err != nil {
+		writeResponse(w, err, nil)
+		return
+	}
+
+	if !hasIngestToken(r, batch.Token) {
+		writeStatusResponse(w, http.StatusUnauthorized, errInvalidToken, nil)
+		return
+	}
+
+	if len(batch.Events) > maxBatchSize {
+		writeResponse(w, fmt.Errorf("A batch cannot have more than %d events", maxBatchSize), nil)
+		return
+	}
+
+	maxAgeConfig, _ := model.GetConfigValue("MAX_EVENT_AGE")
+	maxAge, err := model.ParseDuration(maxAgeConfig)
+	if err != nil {
+		writeStatusResponse(w, http.StatusInternalServerError, errors.New("Invalid MAX_EVENT_AGE"), nil)
+		return
+	}
+
+	var result BatchResult
+	var rateLimitErr *RateLimitError
+	var retryAfter time.Duration
+
+	client := clientKey(r)
+	now := time.Now()
+	for i, batchEvent := range batch.Events {
+		sample := Sample{
+			Event:      batchEvent.Event,
+			Count:      1,
+			Value:      batchEvent.Value,
+			Dimensions: batchEvent.Dimensions,
+		}
+		if batchEvent.Count != nil {
+			sample.Count = *batchEvent.Count
+		}
+
+		err = nil
+		if sample.Count > maxBatchEventCount {
+			err = fmt.Errorf("Count cannot be more than %d", maxBatchEventCount)
+		}
+
+		// Times ahead of the server clock are recorded as now
+		if batchEvent.Time != nil && *batchEvent.Time < now.Unix() {
+			sample.Time = time.Unix(*batchEvent.Time, 0)
+			if maxAge > 0 && now.Sub(sample.Time) > maxAge {
+				err = errors.New("Time is older than MAX_EVENT_AGE")
+			}
+		}
+
+		if err == nil {
+			err = IngestSample(client, sample)
+		}
+		if errors.Is(err, ErrEventPending) {
+			result.Pending++
+			continue
+		}
+
+		if errors.As(err, &rateLimitErr) {
+			result.RateLimited = append(result.RateLimited, i)
+			retryAfter = max(retryAfter, rateLimitErr.RetryAfter)
+			continue
+		}
+
+		if err != nil {
+			result.Rejected++
+			result.Errors = append(result.Errors, BatchError{Index: i, Event: batchEvent.Event, Message: err.Error()})
+			continue
+		}
+
+		result.Accepted++
+	}
+
+	// Nothing was recorded because of the rate limits, the client should
+	// retry the whole batch later
+	if len(result.RateLimited) == len(batch.Events) && len(batch.Events) > 0 {
+		writeIngestError(w, &RateLimitError{Scope: rateLimitErr.Scope, RetryAfter: retryAfter})
+		return
+	}
+
+	// Otherwise only the rate limited events are to be sent again
+	if len(result.RateLimited) > 0 {
+		setRetryAfter(w, retryAfter)
+	}
+
+	writeResponse(w, nil, result)
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"minim/model"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Route groups with their own CORS policy. Ingest covers the event
+// submission routes that are called from tracked sites, admin covers
+// everything used by the web UI to manage dashboards and graphs.
+const (
+	CorsIngest = "INGEST"
+	CorsAdmin  = "ADMIN"
+)
+
+type corsPolicy struct {
+	origins     []string
+	methods     []string
+	credentials bool
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+func getCorsPolicy(group string) corsPolicy {
+	origins, _ := model.GetConfigValue("CORS_" + group + "_ORIGINS")
+	methods, _ := model.GetConfigValue("CORS_" + group + "_METHODS")
+	credentials, _ := model.GetConfigValue("CORS_" + group + "_CREDENTIALS")
+
+	return corsPolicy{
+		origins:     splitList(origins),
+		methods:     splitList(strings.ToUpper(methods)),
+		credentials: credentials == "1",
+	}
+}
+
+func (p corsPolicy) allowsOrigin(origin string) bool {
+	origin = strings.TrimSuffix(origin, "/")
+	for _, allowed := range p.origins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p corsPolicy) allowsMethod(method string) bool {
+	for _, allowed := range p.methods {
+		if allowed == method {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isSameOrigin(r *http.Request, origin string) bool {
+	originUrl, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(originUrl.Host, r.Host)
+}
+
+// Cors wraps a handler with the CORS policy configured for the route group.
+// Requests without an Origin header and same-origin requests are passed
+// through untouched, cross-origin requests from origins outside the policy
+// are rejected.
+func Cors(group string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || isSameOrigin(r, origin) {
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+
+		policy := getCorsPolicy(group)
+		if !policy.allowsOrigin(origin) {
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+			return
+		}
+
+		// A wildcard cannot be combined with credentials, so the origin is
+		// echoed back whenever credentials are enabled
+		allowOrigin := origin
+		if !policy.credentials && len(policy.origins) == 1 && policy.origins[0] == "*" {
+			allowOrigin = "*"
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+		if policy.credentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		requestMethod := r.Header.Get("Access-Control-Request-Method")
+		if r.Method == http.MethodOptions && requestMethod != "" {
+			if !policy.allowsMethod(strings.ToUpper(requestMethod)) {
+				http.Error(w, "Method not allowed", http.StatusForbidden)
+				return
+			}
+
+			allowHeaders := r.Header.Get("Access-Control-Request-Headers")
+			if allowHeaders == "" {
+				allowHeaders = "Content-Type, Authorization"
+			}
+
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(policy.methods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", allowHeaders)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if !policy.allowsMethod(r.Method) {
+			http.Error(w, "Method not allowed", http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func IngestMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return Cors(CorsIngest, requireIngestToken(next))
+}
+
+// IngestBodyMiddleware is IngestMiddleware for handlers that check their
+// credentials themselves, such as batches carrying the ingest token in their
+// body and relays authenticated by origin
+func IngestBodyMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return Cors(CorsIngest, next)
+}
+
+func AdminMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return Cors(CorsAdmin, next)
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"minim/model"
+	"net/http"
+	"strings"
+)
+
+// parseExportQuery reads the event, from and to parameters of an export,
+// events are repeated or comma separated. Secrets are only exported from
+// the command line.
+func parseExportQuery(r *http.Request) (model.ExportQuery, error) {
+	var query model.ExportQuery
+	values := r.URL.Query()
+
+	for _, value := range values["event"] {
+		for _, event := range strings.Split(value, ",") {
+			if event = strings.TrimSpace(event); event != "" {
+				query.Events = append(query.Events, event)
+			}
+		}
+	}
+
+	var err error
+	query.From, err = model.ParseExportTime(values.Get("from"), false)
+	if err != nil {
+		return query, err
+	}
+
+	query.To, err = model.ParseExportTime(values.Get("to"), true)
+	if err != nil {
+		return query, err
+	}
+
+	return query, nil
+}
+
+// handleExport answers with the JSON export, or with the CSV buckets of a
+// single event when format is csv
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	query, err := parseExportQuery(r)
+	if err != nil {
+		writeResponse(w, err, nil)
+		return
+	}
+
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		export, err := model.ExportData(query)
+		if err != nil {
+			writeResponse(w, err, nil)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", `attachment; filename="minim-export.json"`)
+		json.NewEncoder(w).Encode(export)
+
+	case "csv":
+		if len(query.Events) != 1 {
+			writeResponse(w, errors.New("A CSV export needs exactly one event"), nil)
+			return
+		}
+
+		exportEvents, err := model.ExportEvents(query)
+		if err != nil {
+			writeResponse(w, err, nil)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+query.Events[0]+`.csv"`)
+		model.WriteExportCsv(w, exportEvents[0])
+
+	default:
+		writeResponse(w, errors.New("Invalid format"), nil)
+	}
+}
+
+func handleImport(w http.ResponseWriter, r *http.Request) {
+	var export model.Export
+	err := json.NewDecoder(r.Body).Decode(&export)
+	if err != nil {
+		writeResponse(w, err, nil)
+		return
+	}
+
+	// The config of the export, with its tokens and admin settings, is only
+	// imported from the command line
+	query := model.ImportQuery{Conflict: r.URL.Query().Get("conflict")}
+	result, err := model.ImportData(export, query)
+	writeResponse(w, err, result)
+}
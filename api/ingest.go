@@ -0,0 +1,185 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"minim/model"
+	"strings"
+	"time"
+)
+
+var ErrEventLimit = model.ErrEventLimit
+var ErrUnknownEvent = errors.New("Unknown event")
+var ErrEventPending = errors.New("Event is pending approval")
+
+// Values of the EVENT_POLICY config deciding what happens to events that
+// have not been seen before
+const (
+	EventPolicyAuto       = "AUTO"
+	EventPolicyAllowlist  = "ALLOWLIST"
+	EventPolicyQuarantine = "QUARANTINE"
+)
+
+type RateLimitError struct {
+	Scope      string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("Rate limit exceeded for %s", e.Scope)
+}
+
+// Sample is a measurement of an event: Count occurrences and an optional
+// Value aggregated into the buckets, e.g. a gauge or a timer. A zero Time
+// means now, Dimensions break the sample down by key.
+type Sample struct {
+	Event      string
+	Count      int64
+	Value      *float64
+	Time       time.Time
+	Dimensions map[string]string
+}
+
+// Ingest records a single occurrence of event on behalf of client after
+// applying the rate limits and the policy for unknown events
+func Ingest(client string, event string) error {
+	return IngestSample(client, Sample{Event: event, Count: 1})
+}
+
+// IngestSample records a sample through the same rate limits and event
+// policy as Ingest
+func IngestSample(client string, sample Sample) error {
+	err := ingestSample(client, sample)
+	recordIngest(err)
+	return err
+}
+
+func ingestSample(client string, sample Sample) error {
+	event := sample.Event
+	if event == "" {
+		return errors.New("Event value cannot be empty")
+	}
+
+	if !model.IsValidEventName(event) {
+		return errors.New("Invalid event name")
+	}
+
+	if sample.Count < 0 {
+		return errors.New("Count cannot be negative")
+	}
+
+	// A sample costs one token per occurrence it records. One larger than
+	// the burst can never pass and is rejected rather than rate limited so
+	// clients do not retry it.
+	cost := float64(sample.Count)
+
+	if !clientLimiter.fits(cost) {
+		recordReject("client", event)
+		return errors.New("Count is above the client rate limit burst")
+	}
+
+	if !eventLimiter.fits(cost) {
+		recordReject("event", event)
+		return errors.New("Count is above the event rate limit burst")
+	}
+
+	ok, wait := clientLimiter.allow(client, cost)
+	if !ok {
+		recordReject("client", event)
+		return &RateLimitError{Scope: "client", RetryAfter: wait}
+	}
+
+	ok, wait = eventLimiter.allow(event, cost)
+	if !ok {
+		recordReject("event", event)
+		return &RateLimitError{Scope: "event", RetryAfter: wait}
+	}
+
+	exists, err := model.IsValidEvent(event)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		err = ingestUnknownEvent(event)
+		if err != nil {
+			return err
+		}
+	}
+
+	sampleTime := sample.Time
+	if sampleTime.IsZero() {
+		sampleTime = time.Now()
+	}
+
+	err = model.SubmitEventSample(event, sample.Count, sample.Value, sampleTime)
+	if err != nil {
+		return err
+	}
+
+	err = model.SubmitDimensionSample(event, sample.Dimensions, sample.Count, sample.Value, sampleTime)
+	if err != nil {
+		return err
+	}
+
+	model.RecordEventSeen(event, sample.Count, sampleTime)
+
+	return nil
+}
+
+// toEventName maps the characters not allowed in event names, such as the
+// dots of metric names, to underscores
+func toEventName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// ingestUnknownEvent applies the event policy to an event without a
+// definition. A nil error means the event has been created and can be
+// recorded.
+func ingestUnknownEvent(event string) error {
+	policy, _ := model.GetConfigValue("EVENT_POLICY")
+
+	switch strings.ToUpper(policy) {
+	case EventPolicyAllowlist:
+		recordReject("unknown", event)
+		return ErrUnknownEvent
+
+	case EventPolicyQuarantine:
+		pending, err := model.IsPendingEvent(event)
+		if err != nil {
+			return err
+		}
+
+		maxEvents := model.MaxEvents()
+		if !pending && maxEvents > 0 {
+			count, err := model.CountPendingEvents()
+			if err != nil {
+				return err
+			}
+
+			if count >= maxEvents {
+				recordReject("eventLimit", event)
+				return ErrEventLimit
+			}
+		}
+
+		err = model.SubmitPendingEvent(event)
+		if err != nil {
+			return err
+		}
+
+		return ErrEventPending
+
+	default:
+		_, err := model.CreateLimitedEventDef(event)
+		if errors.Is(err, ErrEventLimit) {
+			recordReject("eventLimit", event)
+		}
+		return err
+	}
+}
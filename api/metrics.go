@@ -0,0 +1,244 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"minim/model"
+	"net/http"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Upper bounds in seconds of the request latency histogram buckets
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type requestKey struct {
+	route  string
+	method string
+	code   int
+}
+
+type latencyHistogram struct {
+	buckets []int64
+	count   int64
+	sum     float64
+}
+
+var startTime = time.Now()
+
+var metricsMu sync.Mutex
+var requestCounts = make(map[requestKey]int64)
+var requestLatencies = make(map[string]*latencyHistogram)
+var ingestResults = make(map[string]int64)
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// MetricsMiddleware counts the requests and measures the latency of every
+// route of the router
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(recorder, r)
+
+		route := "other"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+
+		observeRequest(requestKey{route: route, method: r.Method, code: recorder.status}, time.Since(start))
+	})
+}
+
+func observeRequest(key requestKey, duration time.Duration) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	requestCounts[key]++
+
+	histogram, ok := requestLatencies[key.route]
+	if !ok {
+		histogram = &latencyHistogram{buckets: make([]int64, len(latencyBuckets))}
+		requestLatencies[key.route] = histogram
+	}
+
+	seconds := duration.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			histogram.buckets[i]++
+		}
+	}
+	histogram.count++
+	histogram.sum += seconds
+}
+
+// recordIngest counts the outcome of an ingested event
+func recordIngest(err error) {
+	result := "accepted"
+
+	var rateLimitErr *RateLimitError
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrEventPending):
+		result = "pending"
+	case errors.As(err, &rateLimitErr):
+		result = "rate_limited"
+	case errors.Is(err, ErrUnknownEvent), errors.Is(err, ErrEventLimit):
+		result = "rejected"
+	default:
+		result = "error"
+	}
+
+	metricsMu.Lock()
+	ingestResults[result]++
+	metricsMu.Unlock()
+}
+
+// labelValue escapes a string for use as a Prometheus label value
+func labelValue(value string) string {
+	value = strings.ToValidUTF8(value, "�")
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func writeMetricHeader(w io.Writer, name string, metricType string, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+}
+
+func writeEventMetrics(w io.Writer) error {
+	eventCounts, err := model.GetEventCounts()
+	if err != nil {
+		return err
+	}
+
+	writeMetricHeader(w, "minim_event_count", "gauge", "Count of the current minute, hour and day of an event.")
+	for _, counts := range eventCounts {
+		event := labelValue(counts.Event)
+		fmt.Fprintf(w, "minim_event_count{event=\"%s\",period=\"minute\"} %d\n", event, counts.Minute)
+		fmt.Fprintf(w, "minim_event_count{event=\"%s\",period=\"hour\"} %d\n", event, counts.Hour)
+		fmt.Fprintf(w, "minim_event_count{event=\"%s\",period=\"day\"} %d\n", event, counts.Day)
+	}
+
+	writeMetricHeader(w, "minim_event_total", "counter", "All-time total of an event.")
+	for _, counts := range eventCounts {
+		fmt.Fprintf(w, "minim_event_total{event=\"%s\"} %d\n", labelValue(counts.Event), counts.Total)
+	}
+
+	return nil
+}
+
+func writeServerMetrics(w io.Writer) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	keys := make([]requestKey, 0, len(requestCounts))
+	for key := range requestCounts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		if keys[i].method != keys[j].method {
+			return keys[i].method < keys[j].method
+		}
+		return keys[i].code < keys[j].code
+	})
+
+	writeMetricHeader(w, "minim_http_requests_total", "counter", "HTTP requests by route, method and status code.")
+	for _, key := range keys {
+		fmt.Fprintf(w, "minim_http_requests_total{route=\"%s\",method=\"%s\",code=\"%d\"} %d\n",
+			labelValue(key.route), labelValue(key.method), key.code, requestCounts[key])
+	}
+
+	routes := make([]string, 0, len(requestLatencies))
+	for route := range requestLatencies {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+
+	writeMetricHeader(w, "minim_http_request_duration_seconds", "histogram", "Latency of HTTP requests by route.")
+	for _, route := range routes {
+		histogram := requestLatencies[route]
+		label := labelValue(route)
+		for i, bound := range latencyBuckets {
+			fmt.Fprintf(w, "minim_http_request_duration_seconds_bucket{route=\"%s\",le=\"%g\"} %d\n", label, bound, histogram.buckets[i])
+		}
+		fmt.Fprintf(w, "minim_http_request_duration_seconds_bucket{route=\"%s\",le=\"+Inf\"} %d\n", label, histogram.count)
+		fmt.Fprintf(w, "minim_http_request_duration_seconds_sum{route=\"%s\"} %g\n", label, histogram.sum)
+		fmt.Fprintf(w, "minim_http_request_duration_seconds_count{route=\"%s\"} %d\n", label, histogram.count)
+	}
+
+	writeMetricHeader(w, "minim_ingest_events_total", "counter", "Ingested events by result.")
+	for _, result := range []string{"accepted", "pending", "rate_limited", "rejected", "error"} {
+		fmt.Fprintf(w, "minim_ingest_events_total{result=\"%s\"} %d\n", result, ingestResults[result])
+	}
+
+	writeMetricHeader(w, "minim_sqlite_errors_total", "counter", "Errors returned by SQLite.")
+	fmt.Fprintf(w, "minim_sqlite_errors_total %d\n", model.GetDbErrorCount())
+}
+
+func writeRuntimeMetrics(w io.Writer) {
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+
+	writeMetricHeader(w, "go_goroutines", "gauge", "Number of goroutines that currently exist.")
+	fmt.Fprintf(w, "go_goroutines %d\n", runtime.NumGoroutine())
+
+	writeMetricHeader(w, "go_memstats_alloc_bytes", "gauge", "Number of bytes allocated and still in use.")
+	fmt.Fprintf(w, "go_memstats_alloc_bytes %d\n", memStats.Alloc)
+
+	writeMetricHeader(w, "go_memstats_sys_bytes", "gauge", "Number of bytes obtained from the system.")
+	fmt.Fprintf(w, "go_memstats_sys_bytes %d\n", memStats.Sys)
+
+	writeMetricHeader(w, "go_gc_cycles_total", "counter", "Number of completed GC cycles.")
+	fmt.Fprintf(w, "go_gc_cycles_total %d\n", memStats.NumGC)
+
+	writeMetricHeader(w, "process_start_time_seconds", "gauge", "Start time of the process since the unix epoch in seconds.")
+	fmt.Fprintf(w, "process_start_time_seconds %d\n", startTime.Unix())
+}
+
+// HandleMetrics exposes event counts and server metrics in the Prometheus
+// text format
+func HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Totals are only written periodically, flush them so they are current
+	err := model.FlushEventStats()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var body strings.Builder
+	err = writeEventMetrics(&body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeServerMetrics(&body)
+	writeRuntimeMetrics(&body)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	io.WriteString(w, body.String())
+}
@@ -0,0 +1,68 @@
+// Minimalytics tracking script, served by the server at /api/event/minim.js
+(function () {
+  "use strict";
+
+  var token = __MINIM_TOKEN__;
+  var maxBatchSize = 100;
+  var flushSize = 20;
+  var flushDelay = 5000;
+
+  var script = document.currentScript;
+  var base = script.src.replace(/minim\.js(\?.*)?$/, "");
+  var url = base + "batch";
+
+  var queue = [];
+  var timer = null;
+
+  // Beacons are sent as text/plain so they never need a CORS preflight, and
+  // cannot set headers so the token goes in the body
+  function send(events) {
+    var body = JSON.stringify(token ? { token: token, events: events } : { events: events });
+    if (navigator.sendBeacon && navigator.sendBeacon(url, body)) {
+      return;
+    }
+
+    fetch(url, { method: "POST", body: body, keepalive: true, credentials: "omit" })["catch"](function () {});
+  }
+
+  function flush() {
+    if (timer) {
+      clearTimeout(timer);
+      timer = null;
+    }
+
+    while (queue.length) {
+      send(queue.splice(0, maxBatchSize));
+    }
+  }
+
+  function track(name, value, dimensions) {
+    var event = { event: name };
+    if (typeof value === "number" && isFinite(value)) {
+      event.value = value;
+    }
+    if (dimensions) {
+      event.dimensions = dimensions;
+    }
+
+    queue.push(event);
+    if (queue.length >= flushSize) {
+      flush();
+    } else if (!timer) {
+      timer = setTimeout(flush, flushDelay);
+    }
+  }
+
+  document.addEventListener("visibilitychange", function () {
+    if (document.visibilityState === "hidden") {
+      flush();
+    }
+  });
+  window.addEventListener("pagehide", flush);
+
+  window.minim = { track: track, flush: flush };
+
+  if (script.getAttribute("data-auto") !== "false") {
+    track("page_view", null, { path: location.pathname });
+  }
+})();
@@ -0,0 +1,620 @@
+package api
+
+import (
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Largest OTLP request body accepted, after decompression
+const maxOtlpBodySize = 16 << 20
+
+// Number of cumulative sum series remembered to compute deltas
+const maxCumulativeSeries = 10000
+
+// Values of the aggregation temporality of OTLP sums
+const (
+	otlpTemporalityDelta      = 1
+	otlpTemporalityCumulative = 2
+)
+
+// The OTLP types below follow the JSON encoding of the OTLP metrics protocol,
+// the protobuf decoder fills the same types. Only the fields needed to map
+// sums and gauges onto events are kept.
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpMetric struct {
+	Name                 string           `json:"name"`
+	Gauge                *otlpGauge       `json:"gauge"`
+	Sum                  *otlpSum         `json:"sum"`
+	Histogram            *otlpUnsupported `json:"histogram"`
+	ExponentialHistogram *otlpUnsupported `json:"exponentialHistogram"`
+	Summary              *otlpUnsupported `json:"summary"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberDataPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpNumberDataPoint `json:"dataPoints"`
+	AggregationTemporality int                   `json:"aggregationTemporality"`
+	IsMonotonic            bool                  `json:"isMonotonic"`
+}
+
+// otlpUnsupported only counts the data points of histograms and summaries so
+// they can be reported as rejected
+type otlpUnsupported struct {
+	DataPoints []json.RawMessage `json:"dataPoints"`
+}
+
+type otlpNumberDataPoint struct {
+	Attributes   []otlpKeyValue `json:"attributes"`
+	TimeUnixNano otlpInt64      `json:"timeUnixNano"`
+	AsDouble     *float64       `json:"asDouble"`
+	AsInt        *otlpInt64     `json:"asInt"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string    `json:"stringValue"`
+	BoolValue   *bool      `json:"boolValue"`
+	IntValue    *otlpInt64 `json:"intValue"`
+	DoubleValue *float64   `json:"doubleValue"`
+}
+
+// otlpInt64 accepts 64 bit integers encoded as JSON strings or numbers
+type otlpInt64 int64
+
+func (i *otlpInt64) UnmarshalJSON(data []byte) error {
+	value, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	if err != nil {
+		return err
+	}
+
+	*i = otlpInt64(value)
+	return nil
+}
+
+func (v otlpAnyValue) String() (string, bool) {
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue, true
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue), true
+	case v.IntValue != nil:
+		return strconv.FormatInt(int64(*v.IntValue), 10), true
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'g', -1, 64), true
+	}
+
+	return "", false
+}
+
+func (p otlpNumberDataPoint) value() (float64, bool) {
+	if p.AsInt != nil {
+		return float64(*p.AsInt), true
+	}
+
+	if p.AsDouble != nil && !math.IsNaN(*p.AsDouble) && !math.IsInf(*p.AsDouble, 0) {
+		return *p.AsDouble, true
+	}
+
+	return 0, false
+}
+
+type protoField struct {
+	number   int
+	wireType int
+	value    uint64
+	data     []byte
+}
+
+var errInvalidProtobuf = errors.New("Invalid protobuf message")
+
+// readProtoFields splits a protobuf message into its fields, varint and
+// fixed size values are returned in value, length delimited ones in data
+func readProtoFields(b []byte) ([]protoField, error) {
+	var fields []protoField
+
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, errInvalidProtobuf
+		}
+		b = b[n:]
+
+		field := protoField{number: int(key >> 3), wireType: int(key & 7)}
+		switch field.wireType {
+		case 0:
+			field.value, n = binary.Uvarint(b)
+			if n <= 0 {
+				return nil, errInvalidProtobuf
+			}
+			b = b[n:]
+
+		case 1:
+			if len(b) < 8 {
+				return nil, errInvalidProtobuf
+			}
+			field.value = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+
+		case 2:
+			length, n := binary.Uvarint(b)
+			if n <= 0 || length > uint64(len(b)-n) {
+				return nil, errInvalidProtobuf
+			}
+			field.data = b[n : n+int(length)]
+			b = b[n+int(length):]
+
+		case 5:
+			if len(b) < 4 {
+				return nil, errInvalidProtobuf
+			}
+			field.value = uint64(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+
+		default:
+			return nil, errInvalidProtobuf
+		}
+
+		fields = append(fields, field)
+	}
+
+	return fields, nil
+}
+
+// decodeProto reads the fields of a message, handle is called for every
+// field and returns the error of the nested messages it decodes
+func decodeProto(b []byte, handle func(field protoField) error) error {
+	fields, err := readProtoFields(b)
+	if err != nil {
+		return err
+	}
+
+	for _, field := range fields {
+		err = handle(field)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func decodeProtoKeyValue(b []byte) (otlpKeyValue, error) {
+	var keyValue otlpKeyValue
+
+	err := decodeProto(b, func(field protoField) error {
+		switch {
+		case field.number == 1 && field.wireType == 2:
+			keyValue.Key = string(field.data)
+
+		case field.number == 2 && field.wireType == 2:
+			return decodeProto(field.data, func(field protoField) error {
+				switch {
+				case field.number == 1 && field.wireType == 2:
+					value := string(field.data)
+					keyValue.Value.StringValue = &value
+				case field.number == 2 && field.wireType == 0:
+					value := field.value != 0
+					keyValue.Value.BoolValue = &value
+				case field.number == 3 && field.wireType == 0:
+					value := otlpInt64(field.value)
+					keyValue.Value.IntValue = &value
+				case field.number == 4 && field.wireType == 1:
+					value := math.Float64frombits(field.value)
+					keyValue.Value.DoubleValue = &value
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+
+	return keyValue, err
+}
+
+func decodeProtoDataPoint(b []byte) (otlpNumberDataPoint, error) {
+	var dataPoint otlpNumberDataPoint
+
+	err := decodeProto(b, func(field protoField) error {
+		switch {
+		case field.number == 3 && field.wireType == 1:
+			dataPoint.TimeUnixNano = otlpInt64(field.value)
+		case field.number == 4 && field.wireType == 1:
+			value := math.Float64frombits(field.value)
+			dataPoint.AsDouble = &value
+		case field.number == 6 && field.wireType == 1:
+			value := otlpInt64(field.value)
+			dataPoint.AsInt = &value
+		case field.number == 7 && field.wireType == 2:
+			keyValue, err := decodeProtoKeyValue(field.data)
+			dataPoint.Attributes = append(dataPoint.Attributes, keyValue)
+			return err
+		}
+		return nil
+	})
+
+	return dataPoint, err
+}
+
+func decodeProtoDataPoints(dataPoints *[]otlpNumberDataPoint) func(field protoField) error {
+	return func(field protoField) error {
+		if field.number != 1 || field.wireType != 2 {
+			return nil
+		}
+
+		dataPoint, err := decodeProtoDataPoint(field.data)
+		*dataPoints = append(*dataPoints, dataPoint)
+		return err
+	}
+}
+
+func decodeProtoMetric(b []byte) (otlpMetric, error) {
+	var metric otlpMetric
+
+	err := decodeProto(b, func(field protoField) error {
+		if field.wireType != 2 {
+			return nil
+		}
+
+		switch field.number {
+		case 1:
+			metric.Name = string(field.data)
+
+		case 5:
+			metric.Gauge = &otlpGauge{}
+			return decodeProto(field.data, decodeProtoDataPoints(&metric.Gauge.DataPoints))
+
+		case 7:
+			metric.Sum = &otlpSum{}
+			return decodeProto(field.data, func(field protoField) error {
+				switch {
+				case field.number == 2 && field.wireType == 0:
+					metric.Sum.AggregationTemporality = int(field.value)
+					return nil
+				case field.number == 3 && field.wireType == 0:
+					metric.Sum.IsMonotonic = field.value != 0
+					return nil
+				}
+				return decodeProtoDataPoints(&metric.Sum.DataPoints)(field)
+			})
+
+		case 9, 10, 11:
+			unsupported := &otlpUnsupported{}
+			metric.Histogram = unsupported
+			return decodeProto(field.data, func(field protoField) error {
+				if field.number == 1 && field.wireType == 2 {
+					unsupported.DataPoints = append(unsupported.DataPoints, nil)
+				}
+				return nil
+			})
+		}
+
+		return nil
+	})
+
+	return metric, err
+}
+
+func decodeProtoRequest(b []byte) (otlpRequest, error) {
+	var request otlpRequest
+
+	err := decodeProto(b, func(field protoField) error {
+		if field.number != 1 || field.wireType != 2 {
+			return nil
+		}
+
+		var resourceMetrics otlpResourceMetrics
+		err := decodeProto(field.data, func(field protoField) error {
+			if field.wireType != 2 {
+				return nil
+			}
+
+			switch field.number {
+			case 1:
+				return decodeProto(field.data, func(field protoField) error {
+					if field.number != 1 || field.wireType != 2 {
+						return nil
+					}
+
+					keyValue, err := decodeProtoKeyValue(field.data)
+					resourceMetrics.Resource.Attributes = append(resourceMetrics.Resource.Attributes, keyValue)
+					return err
+				})
+
+			case 2:
+				var scopeMetrics otlpScopeMetrics
+				err := decodeProto(field.data, func(field protoField) error {
+					if field.number != 2 || field.wireType != 2 {
+						return nil
+					}
+
+					metric, err := decodeProtoMetric(field.data)
+					scopeMetrics.Metrics = append(scopeMetrics.Metrics, metric)
+					return err
+				})
+				resourceMetrics.ScopeMetrics = append(resourceMetrics.ScopeMetrics, scopeMetrics)
+				return err
+			}
+
+			return nil
+		})
+
+		request.ResourceMetrics = append(request.ResourceMetrics, resourceMetrics)
+		return err
+	})
+
+	return request, err
+}
+
+var cumulativeMu sync.Mutex
+var cumulativeValues = make(map[string]float64)
+
+var errCumulativeSeriesLimit = errors.New("Too many cumulative series")
+
+// cumulativeDelta turns the value of a cumulative sum into the increase
+// since the previous export. The first export of a series only sets the
+// baseline, a value lower than the previous one means the sum was reset.
+// New series beyond maxCumulativeSeries cannot be tracked and are rejected.
+func cumulativeDelta(series string, value float64) (float64, bool, error) {
+	cumulativeMu.Lock()
+	defer cumulativeMu.Unlock()
+
+	previous, ok := cumulativeValues[series]
+	if !ok && len(cumulativeValues) >= maxCumulativeSeries {
+		return 0, false, errCumulativeSeriesLimit
+	}
+	cumulativeValues[series] = value
+
+	if !ok {
+		return 0, false, nil
+	}
+
+	if value < previous {
+		return value, true, nil
+	}
+
+	return value - previous, true, nil
+}
+
+func seriesKey(event string, dimensions map[string]string) string {
+	keys := make([]string, 0, len(dimensions))
+	for key := range dimensions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var series strings.Builder
+	series.WriteString(event)
+	for _, key := range keys {
+		fmt.Fprintf(&series, "|%s=%s", key, dimensions[key])
+	}
+
+	return series.String()
+}
+
+// otlpSamples maps the data points of sums and gauges onto samples. Monotonic
+// sums are counted, gauges and non-monotonic sums are aggregated as values.
+// Point attributes and the service.name of the resource become dimensions.
+// The number of data points that could not be mapped is returned as well.
+func otlpSamples(request otlpRequest) ([]Sample, int64) {
+	var samples []Sample
+	var rejected int64
+
+	for _, resourceMetrics := range request.ResourceMetrics {
+		service := ""
+		for _, attribute := range resourceMetrics.Resource.Attributes {
+			if attribute.Key == "service.name" {
+				service, _ = attribute.Value.String()
+			}
+		}
+
+		for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+			for _, metric := range scopeMetrics.Metrics {
+				for _, unsupported := range []*otlpUnsupported{metric.Histogram, metric.ExponentialHistogram, metric.Summary} {
+					if unsupported != nil {
+						rejected += int64(len(unsupported.DataPoints))
+					}
+				}
+
+				var dataPoints []otlpNumberDataPoint
+				if metric.Gauge != nil {
+					dataPoints = append(dataPoints, metric.Gauge.DataPoints...)
+				}
+				if metric.Sum != nil {
+					dataPoints = append(dataPoints, metric.Sum.DataPoints...)
+				}
+
+				event := toEventName(metric.Name)
+				for _, dataPoint := range dataPoints {
+					value, ok := dataPoint.value()
+					if !ok {
+						rejected++
+						continue
+					}
+
+					sample := Sample{
+						Event:      event,
+						Count:      1,
+						Dimensions: make(map[string]string),
+					}
+
+					if dataPoint.TimeUnixNano > 0 {
+						sample.Time = time.Unix(0, int64(dataPoint.TimeUnixNano))
+					}
+
+					if service != "" {
+						sample.Dimensions["service.name"] = service
+					}
+					for _, attribute := range dataPoint.Attributes {
+						if attributeValue, ok := attribute.Value.String(); ok {
+							sample.Dimensions[attribute.Key] = attributeValue
+						}
+					}
+
+					if metric.Sum != nil && metric.Sum.IsMonotonic {
+						if metric.Sum.AggregationTemporality == otlpTemporalityCumulative {
+							var err error
+							value, ok, err = cumulativeDelta(seriesKey(event, sample.Dimensions), value)
+							if err != nil {
+								rejected++
+								continue
+							}
+							if !ok {
+								continue
+							}
+						} else if metric.Sum.AggregationTemporality != otlpTemporalityDelta {
+							rejected++
+							continue
+						}
+
+						sample.Count = int64(math.Round(value))
+						if sample.Count == 0 {
+							continue
+						}
+					} else {
+						sample.Value = &value
+					}
+
+					samples = append(samples, sample)
+				}
+			}
+		}
+	}
+
+	return samples, rejected
+}
+
+func writeOtlpResponse(w http.ResponseWriter, isProtobuf bool, rejected int64, message string) {
+	if isProtobuf {
+		var body []byte
+		if rejected > 0 {
+			var partialSuccess []byte
+			partialSuccess = binary.AppendUvarint(partialSuccess, 1<<3|0)
+			partialSuccess = binary.AppendUvarint(partialSuccess, uint64(rejected))
+			partialSuccess = binary.AppendUvarint(partialSuccess, 2<<3|2)
+			partialSuccess = binary.AppendUvarint(partialSuccess, uint64(len(message)))
+			partialSuccess = append(partialSuccess, message...)
+
+			body = binary.AppendUvarint(body, 1<<3|2)
+			body = binary.AppendUvarint(body, uint64(len(partialSuccess)))
+			body = append(body, partialSuccess...)
+		}
+
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(body)
+		return
+	}
+
+	response := map[string]any{}
+	if rejected > 0 {
+		response["partialSuccess"] = map[string]any{
+			"rejectedDataPoints": strconv.FormatInt(rejected, 10),
+			"errorMessage":       message,
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// HandleOtlpMetrics accepts OTLP/HTTP metric exports encoded as protobuf or
+// JSON, optionally gzip compressed
+func HandleOtlpMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	isProtobuf := contentType == "application/x-protobuf" || contentType == "application/protobuf"
+	if !isProtobuf && contentType != "application/json" {
+		http.Error(w, "Unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gzipReader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gzipReader.Close()
+		body = gzipReader
+	}
+
+	data, err := io.ReadAll(io.LimitReader(body, maxOtlpBodySize+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if len(data) > maxOtlpBodySize {
+		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var request otlpRequest
+	if isProtobuf {
+		request, err = decodeProtoRequest(data)
+	} else {
+		err = json.Unmarshal(data, &request)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	samples, rejected := otlpSamples(request)
+
+	message := ""
+	if rejected > 0 {
+		message = "Only sums and gauges with numeric values are supported"
+	}
+
+	client := clientKey(r)
+	for _, sample := range samples {
+		err = IngestSample(client, sample)
+		if err != nil && !errors.Is(err, ErrEventPending) {
+			rejected++
+			message = err.Error()
+		}
+	}
+
+	writeOtlpResponse(w, isProtobuf, rejected, message)
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"minim/model"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// A 1x1 transparent GIF
+var pixelGif = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00, 0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xff, 0xff, 0xff, 0x21, 0xf9, 0x04, 0x01, 0x00, 0x00, 0x00, 0x00, 0x2c, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44, 0x01, 0x00, 0x3b,
+}
+
+// User agents of crawlers, link previews and monitoring tools. Image proxies
+// of mail clients are not filtered as they load the pixel on behalf of a
+// reader.
+var botUserAgentPattern = regexp.MustCompile(`(?i)bot|crawl|spider|slurp|facebookexternalhit|embedly|preview|headless|lighthouse|pingdom|uptime|monitor|curl|wget|python-requests|go-http-client`)
+
+func isBot(userAgent string) bool {
+	return userAgent == "" || botUserAgentPattern.MatchString(userAgent)
+}
+
+// doNotTrack reports whether the browser asked not to be tracked with the
+// DNT or Global Privacy Control headers
+func doNotTrack(r *http.Request) bool {
+	return r.Header.Get("DNT") == "1" || r.Header.Get("Sec-GPC") == "1"
+}
+
+// pixelDimensions returns the path of the tracked page, from the p parameter
+// or the Referer header, and the host of the referrer passed in r
+func pixelDimensions(r *http.Request) map[string]string {
+	dimensions := make(map[string]string)
+	query := r.URL.Query()
+
+	page := query.Get("p")
+	if page == "" {
+		page = r.Referer()
+	}
+	if page != "" {
+		if pageUrl, err := url.Parse(page); err == nil && pageUrl.Path != "" {
+			dimensions["path"] = pageUrl.Path
+		}
+	}
+
+	if referrer := query.Get("r"); referrer != "" {
+		if referrerUrl, err := url.Parse(referrer); err == nil && referrerUrl.Hostname() != "" {
+			dimensions["referrer"] = strings.TrimPrefix(referrerUrl.Hostname(), "www.")
+		}
+	}
+
+	return dimensions
+}
+
+func writePixel(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate, private")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
+	w.Write(pixelGif)
+}
+
+// HandlePixel records the event in the e query parameter and answers with a
+// transparent GIF so it can be embedded in pages and emails without
+// JavaScript. The GIF is returned even when the event is not recorded.
+func HandlePixel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	defer writePixel(w)
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	respectDnt, _ := model.GetConfigValue("PIXEL_RESPECT_DNT")
+	if respectDnt == "1" && doNotTrack(r) {
+		return
+	}
+
+	filterBots, _ := model.GetConfigValue("PIXEL_FILTER_BOTS")
+	if filterBots == "1" && isBot(r.UserAgent()) {
+		return
+	}
+
+	sample := Sample{
+		Event:      r.URL.Query().Get("e"),
+		Count:      1,
+		Dimensions: pixelDimensions(r),
+	}
+
+	err := IngestSample(clientKey(r), sample)
+	if err != nil && !errors.Is(err, ErrEventPending) {
+		log.Printf("Unable to record pixel event %s: %v", sample.Event, err)
+	}
+}
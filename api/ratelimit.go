@@ -0,0 +1,188 @@
+package api
+
+import (
+	"math"
+	"minim/model"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket per key. Buckets are refilled lazily
+// whenever a key is checked, a rate of zero disables the limiter.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+func newRateLimiter(rate float64, burst float64) *rateLimiter {
+	if burst < 1 {
+		burst = math.Max(1, rate)
+	}
+
+	return &rateLimiter{
+		rate:    rate,
+		burst:   burst,
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// fits reports whether a sample of cost tokens can ever pass, a cost above
+// the burst would never find enough tokens in the bucket
+func (l *rateLimiter) fits(cost float64) bool {
+	return l == nil || l.rate <= 0 || math.Max(cost, 1) <= l.burst
+}
+
+// allow takes cost tokens from the bucket of key. Costs above the burst are
+// refused by the caller with fits beforehand.
+func (l *rateLimiter) allow(key string, cost float64) (bool, time.Duration) {
+	if l == nil || l.rate <= 0 {
+		return true, 0
+	}
+
+	cost = math.Max(cost, 1)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = bucket
+	}
+
+	elapsed := now.Sub(bucket.last).Seconds()
+	bucket.tokens = math.Min(l.burst, bucket.tokens+elapsed*l.rate)
+	bucket.last = now
+
+	if bucket.tokens >= cost {
+		bucket.tokens -= cost
+		return true, 0
+	}
+
+	wait := (cost - bucket.tokens) / l.rate
+	return false, time.Duration(wait * float64(time.Second))
+}
+
+// prune drops buckets that have refilled completely, they behave exactly
+// like a fresh bucket so nothing is lost
+func (l *rateLimiter) prune() {
+	if l == nil || l.rate <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+type RejectStats struct {
+	Client     int64            `json:"client"`
+	Event      int64            `json:"event"`
+	EventLimit int64            `json:"eventLimit"`
+	Unknown    int64            `json:"unknown"`
+	Events     map[string]int64 `json:"events"`
+}
+
+var clientLimiter *rateLimiter
+var eventLimiter *rateLimiter
+
+const maxRejectEvents = 1000
+
+var rejectMu sync.Mutex
+var rejectStats = RejectStats{Events: make(map[string]int64)}
+
+func getConfigFloat(key string) float64 {
+	value, err := model.GetConfigValue(key)
+	if err != nil {
+		return 0
+	}
+
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil {
+		return 0
+	}
+
+	return f
+}
+
+// InitRateLimits loads the rate limit config, it is called once when the
+// server starts
+func InitRateLimits() {
+	clientLimiter = newRateLimiter(getConfigFloat("RATE_LIMIT_CLIENT"), getConfigFloat("RATE_LIMIT_CLIENT_BURST"))
+	eventLimiter = newRateLimiter(getConfigFloat("RATE_LIMIT_EVENT"), getConfigFloat("RATE_LIMIT_EVENT_BURST"))
+}
+
+func PruneRateLimits() {
+	clientLimiter.prune()
+	eventLimiter.prune()
+}
+
+func GetRejectStats() RejectStats {
+	rejectMu.Lock()
+	defer rejectMu.Unlock()
+
+	stats := rejectStats
+	stats.Events = make(map[string]int64, len(rejectStats.Events))
+	for event, count := range rejectStats.Events {
+		stats.Events[event] = count
+	}
+
+	return stats
+}
+
+func recordReject(scope string, event string) {
+	rejectMu.Lock()
+	defer rejectMu.Unlock()
+
+	switch scope {
+	case "client":
+		rejectStats.Client++
+	case "event":
+		rejectStats.Event++
+	case "eventLimit":
+		rejectStats.EventLimit++
+	case "unknown":
+		rejectStats.Unknown++
+	}
+
+	if event == "" {
+		return
+	}
+
+	// Rejected names are attacker controlled, keep the breakdown bounded
+	_, seen := rejectStats.Events[event]
+	if !seen && len(rejectStats.Events) >= maxRejectEvents {
+		event = "(other)"
+	}
+	rejectStats.Events[event]++
+}
+
+// clientKey identifies the sender of an event by its address. Tokens are
+// not used as the only one checked, INGEST_TOKEN, is shared by every
+// client and any other value could be changed on every request.
+func clientKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	return "ip:" + host
+}
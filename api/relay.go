@@ -0,0 +1,227 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"minim/model"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Largest number of buckets accepted in one relay request and sent in one
+// request by the forwarder
+const maxRelayBuckets = 5000
+
+// Largest relay request body accepted
+const maxRelayBodySize = 16 << 20
+
+// Longest origin name accepted, the same as dimension values
+const maxRelayOriginLength = 128
+
+// RelayMessage is a batch of buckets. Origin is optional, the receiving end
+// takes it from the relay token and only checks that they agree.
+type RelayMessage struct {
+	Origin  string              `json:"origin,omitempty"`
+	Buckets []model.RelayBucket `json:"buckets"`
+}
+
+// StartRelay forwards the aggregated buckets of this instance to the
+// instance at RELAY_URL every RELAY_INTERVAL seconds when RELAY_URL is set
+func StartRelay() error {
+	target, _ := model.GetConfigValue("RELAY_URL")
+	if target == "" {
+		return nil
+	}
+
+	target = strings.TrimSuffix(target, "/")
+
+	intervalConfig, _ := model.GetConfigValue("RELAY_INTERVAL")
+	interval, err := strconv.Atoi(intervalConfig)
+	if err != nil || interval < 1 {
+		return errors.New("Invalid RELAY_INTERVAL")
+	}
+
+	token, _ := model.GetConfigValue("RELAY_TOKEN")
+
+	origin, _ := model.GetConfigValue("RELAY_HOST")
+	if origin != "" {
+		log.Printf("Relaying buckets to %s as %s every %d seconds", target, origin, interval)
+	} else {
+		log.Printf("Relaying buckets to %s every %d seconds", target, interval)
+	}
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(interval) * time.Second)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			err := forwardBuckets(target, token, origin)
+			if err != nil {
+				log.Println("Unable to relay buckets:", err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// forwardBuckets sends the buckets changed since the last successful forward.
+// The buckets containing the previous forward are sent again as they may
+// have grown since, the receiving end only adds the difference.
+func forwardBuckets(target string, token string, origin string) error {
+	cursor, err := model.GetRelayCursor(target)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+
+	buckets, err := model.GetRelayBuckets(cursor)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+	}
+
+	for offset := 0; offset < len(buckets); offset += maxRelayBuckets {
+		end := min(offset+maxRelayBuckets, len(buckets))
+
+		body, err := json.Marshal(RelayMessage{Origin: origin, Buckets: buckets[offset:end]})
+		if err != nil {
+			return err
+		}
+
+		req, err := http.NewRequest(http.MethodPost, target+"/api/event/relay", bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+
+		var apiResp struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiResp)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("%s: %s", resp.Status, apiResp.Message)
+		}
+	}
+
+	return model.SetRelayCursor(target, start)
+}
+
+// HandleRelay records the buckets forwarded by another instance. The origin
+// is the one of the relay token, a different origin in the body is refused
+// so an origin cannot overwrite the buckets of another. Unknown events go
+// through the event policy, the buckets of events that are rejected or
+// pending are dropped.
+func HandleRelay(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	origin, ok := relayOrigin(r)
+	if !ok {
+		writeStatusResponse(w, http.StatusUnauthorized, errInvalidRelayToken, nil)
+		return
+	}
+
+	var message RelayMessage
+	err := json.NewDecoder(io.LimitReader(r.Body, maxRelayBodySize)).Decode(&message)
+	if err != nil {
+		writeResponse(w, err, nil)
+		return
+	}
+
+	if len(origin) > maxRelayOriginLength || message.Origin != "" && message.Origin != origin {
+		writeResponse(w, errors.New("Invalid origin"), nil)
+		return
+	}
+
+	if len(message.Buckets) > maxRelayBuckets {
+		writeResponse(w, fmt.Errorf("A relay request cannot have more than %d buckets", maxRelayBuckets), nil)
+		return
+	}
+
+	var result BatchResult
+	var accepted []model.RelayBucket
+
+	checked := make(map[string]error)
+	for i, bucket := range message.Buckets {
+		// Relayed buckets only ever grow, negative values would subtract
+		// from the totals
+		if bucket.Count < 0 || bucket.Samples < 0 || bucket.Sum < 0 {
+			result.Rejected++
+			result.Errors = append(result.Errors, BatchError{Index: i, Event: bucket.Event, Message: "Invalid bucket values"})
+			continue
+		}
+
+		eventErr, ok := checked[bucket.Event]
+		if !ok {
+			eventErr = checkRelayEvent(bucket.Event)
+			checked[bucket.Event] = eventErr
+
+			// Reported once for all the buckets of the event
+			if eventErr != nil && !errors.Is(eventErr, ErrEventPending) {
+				result.Errors = append(result.Errors, BatchError{Index: i, Event: bucket.Event, Message: eventErr.Error()})
+			}
+		}
+
+		if errors.Is(eventErr, ErrEventPending) {
+			result.Pending++
+			continue
+		}
+
+		if eventErr != nil {
+			result.Rejected++
+			continue
+		}
+
+		accepted = append(accepted, bucket)
+	}
+
+	hostDimension, _ := model.GetConfigValue("RELAY_HOST_DIMENSION")
+
+	err = model.MergeRelayBuckets(origin, accepted, hostDimension == "1")
+	if err != nil {
+		writeResponse(w, err, nil)
+		return
+	}
+
+	result.Accepted = len(accepted)
+	writeResponse(w, nil, result)
+}
+
+// checkRelayEvent applies the event policy to a relayed event, a nil error
+// means its buckets can be recorded
+func checkRelayEvent(event string) error {
+	if !model.IsValidEventName(event) {
+		return errors.New("Invalid event name")
+	}
+
+	exists, err := model.IsValidEvent(event)
+	if err != nil || exists {
+		return err
+	}
+
+	return ingestUnknownEvent(event)
+}
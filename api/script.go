@@ -0,0 +1,35 @@
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"io"
+	"minim/model"
+	"net/http"
+	"strings"
+)
+
+//go:embed minim.js
+var trackingScript string
+
+// HandleScript serves the tracking script with the configured ingest token
+// filled in. The token ends up in public pages, it only guards ingestion.
+func HandleScript(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	token, _ := model.GetConfigValue("INGEST_TOKEN")
+	tokenJson, err := json.Marshal(token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	script := strings.Replace(trackingScript, "__MINIM_TOKEN__", string(tokenJson), 1)
+
+	w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	io.WriteString(w, script)
+}
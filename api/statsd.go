@@ -0,0 +1,161 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"minim/model"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Largest datagram accepted by the StatsD listener
+const statsdPacketSize = 65535
+
+// Largest count a counter line can record once scaled by its sample rate,
+// larger ones come from a broken client or a tiny rate
+const maxStatsdCount = 1000000
+
+// StartStatsd listens for StatsD datagrams when STATSD_ENABLE is set. Every
+// metric goes through the same ingestion path as HandleEvent.
+func StartStatsd() error {
+	enable, _ := model.GetConfigValue("STATSD_ENABLE")
+	if enable != "1" {
+		return nil
+	}
+
+	port, _ := model.GetConfigValue("STATSD_PORT")
+	prefix, _ := model.GetConfigValue("STATSD_PREFIX")
+
+	conn, err := net.ListenPacket("udp", ":"+port)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Listening for StatsD metrics on port " + port)
+
+	go func() {
+		buffer := make([]byte, statsdPacketSize)
+		for {
+			n, addr, err := conn.ReadFrom(buffer)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+
+			client := "statsd:" + addr.String()
+			if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+				client = "statsd:" + host
+			}
+
+			handleStatsdPacket(client, string(buffer[:n]), prefix)
+		}
+	}()
+
+	return nil
+}
+
+func handleStatsdPacket(client string, packet string, prefix string) {
+	for _, line := range strings.Split(packet, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		sample, err := parseStatsdLine(line, prefix)
+		if err != nil {
+			log.Printf("Invalid StatsD line %q: %v", line, err)
+			continue
+		}
+
+		err = IngestSample(client, sample)
+		if err != nil && !errors.Is(err, ErrEventPending) {
+			log.Printf("Unable to ingest StatsD metric %s: %v", sample.Event, err)
+		}
+	}
+}
+
+// statsdEventName strips the namespace prefix from a metric name, only
+// whole components are stripped so prefix app leaves apple.x alone
+func statsdEventName(name string, prefix string) string {
+	if prefix != "" {
+		if name == prefix {
+			name = ""
+		} else if trimmed, ok := strings.CutPrefix(name, prefix+"."); ok {
+			name = trimmed
+		}
+	}
+
+	return toEventName(name)
+}
+
+// parseStatsdLine parses a line of the StatsD protocol,
+// <name>:<value>|<type>[|@<sample rate>][|#<tags>]. Counters add their value
+// scaled by the sample rate, gauges, timers, histograms and distributions
+// count one occurrence and aggregate their value, sets count one occurrence.
+// Gauges with a sign are deltas to the previous value and are rejected as
+// only absolute values are recorded.
+func parseStatsdLine(line string, prefix string) (Sample, error) {
+	var sample Sample
+
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return sample, errors.New("missing metric name")
+	}
+
+	fields := strings.Split(rest, "|")
+	if len(fields) < 2 {
+		return sample, errors.New("missing metric type")
+	}
+
+	rate := 1.0
+	for _, field := range fields[2:] {
+		if rateValue, ok := strings.CutPrefix(field, "@"); ok {
+			var err error
+			rate, err = strconv.ParseFloat(rateValue, 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return sample, errors.New("invalid sample rate")
+			}
+		}
+	}
+
+	sample.Event = statsdEventName(name, prefix)
+	metricType := fields[1]
+
+	if metricType == "s" {
+		sample.Count = 1
+		return sample, nil
+	}
+
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return sample, errors.New("invalid value")
+	}
+
+	switch metricType {
+	case "c":
+		if value < 0 {
+			return sample, errors.New("counters cannot be negative")
+		}
+		count := math.Round(value / rate)
+		if math.IsInf(count, 0) || count > maxStatsdCount {
+			return sample, fmt.Errorf("counter values cannot be more than %d", maxStatsdCount)
+		}
+		sample.Count = int64(count)
+
+	case "g", "ms", "h", "d":
+		if metricType == "g" && (strings.HasPrefix(fields[0], "+") || strings.HasPrefix(fields[0], "-")) {
+			return sample, errors.New("gauge deltas are not supported")
+		}
+
+		sample.Count = 1
+		sample.Value = &value
+
+	default:
+		return sample, errors.New("unsupported metric type")
+	}
+
+	return sample, nil
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"crypto/subtle"
+	"errors"
+	"minim/model"
+	"net/http"
+	"strings"
+)
+
+var errInvalidToken = errors.New("Invalid ingest token")
+var errInvalidRelayToken = errors.New("Invalid relay token")
+
+func isIngestToken(token string) bool {
+	ingestToken, _ := model.GetConfigValue("INGEST_TOKEN")
+	return ingestToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(ingestToken)) == 1
+}
+
+// relayOrigin returns the origin whose token a relay request carries.
+// RELAY_ORIGINS holds comma separated origin:token pairs, relaying is
+// refused while it is empty.
+func relayOrigin(r *http.Request) (string, bool) {
+	token := requestToken(r)
+	if token == "" {
+		return "", false
+	}
+
+	origins, _ := model.GetConfigValue("RELAY_ORIGINS")
+	for _, pair := range strings.Split(origins, ",") {
+		origin, originToken, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if ok && origin != "" && originToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(originToken)) == 1 {
+			return origin, true
+		}
+	}
+
+	return "", false
+}
+
+// requestToken reads the token of an ingestion request from the
+// Authorization header. It is never read from the URL as URLs end up in
+// access logs and Referer headers.
+func requestToken(r *http.Request) string {
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token
+}
+
+// hasIngestToken reports whether a request carries the INGEST_TOKEN, when
+// one is configured, in its Authorization header or as bodyToken for
+// clients that cannot set headers such as navigator.sendBeacon
+func hasIngestToken(r *http.Request, bodyToken string) bool {
+	ingestToken, _ := model.GetConfigValue("INGEST_TOKEN")
+	if ingestToken == "" {
+		return true
+	}
+
+	return isIngestToken(requestToken(r)) || bodyToken != "" && isIngestToken(bodyToken)
+}
+
+// requireIngestToken rejects ingestion requests without the INGEST_TOKEN
+// once one is configured
+func requireIngestToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodOptions && !hasIngestToken(r, "") {
+			writeStatusResponse(w, http.StatusUnauthorized, errInvalidToken, nil)
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,483 @@
+// Package client sends events to a Minimalytics server and reads its stats,
+// dashboards and graphs.
+//
+//	c := client.New("http://localhost:3333", client.WithToken(token))
+//	defer c.Close()
+//
+//	c.Track("signup")
+//	c.Track("checkout", client.Value(49.90), client.Dimension("plan", "pro"))
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrClosed = errors.New("client is closed")
+var ErrBufferFull = errors.New("event buffer is full")
+
+// Largest number of events the server accepts in one batch
+const maxBatchSize = 100
+
+type batchEvent struct {
+	Event      string            `json:"event"`
+	Count      *int64            `json:"count,omitempty"`
+	Value      *float64          `json:"value,omitempty"`
+	Time       int64             `json:"time"`
+	Dimensions map[string]string `json:"dimensions,omitempty"`
+}
+
+type batchMessage struct {
+	Events []batchEvent `json:"events"`
+}
+
+type batchResult struct {
+	Rejected    int   `json:"rejected"`
+	RateLimited []int `json:"rateLimited"`
+	Errors      []struct {
+		Event   string `json:"event"`
+		Message string `json:"message"`
+	} `json:"errors"`
+}
+
+// Client buffers tracked events and sends them in batches from a background
+// goroutine. It is safe for concurrent use.
+type Client struct {
+	baseUrl    string
+	token      string
+	httpClient *http.Client
+
+	batchSize     int
+	flushInterval time.Duration
+	maxRetries    int
+	retryDelay    time.Duration
+	onError       func(error)
+	spool         *spool
+
+	mu      sync.RWMutex
+	closed  bool
+	events  chan batchEvent
+	flushes chan chan error
+	done    chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type Option func(*Client)
+
+// WithToken sets the ingest token sent with every request
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) { c.httpClient = httpClient }
+}
+
+// WithBatchSize sets the number of events that triggers a send, at most 100
+func WithBatchSize(size int) Option {
+	return func(c *Client) { c.batchSize = min(max(size, 1), maxBatchSize) }
+}
+
+// WithFlushInterval sets how often buffered events are sent
+func WithFlushInterval(interval time.Duration) Option {
+	return func(c *Client) { c.flushInterval = interval }
+}
+
+// WithBufferSize sets the number of events buffered before Track starts
+// returning ErrBufferFull
+func WithBufferSize(size int) Option {
+	return func(c *Client) { c.events = make(chan batchEvent, max(size, 1)) }
+}
+
+// WithRetries sets how many times a failed batch is retried and the delay
+// before the first retry, the delay doubles on every attempt
+func WithRetries(maxRetries int, retryDelay time.Duration) Option {
+	return func(c *Client) {
+		c.maxRetries = maxRetries
+		c.retryDelay = retryDelay
+	}
+}
+
+// WithErrorHandler sets the function called with the errors of background
+// sends, they are dropped by default
+func WithErrorHandler(onError func(error)) Option {
+	return func(c *Client) { c.onError = onError }
+}
+
+// WithSpool keeps the batches that cannot be delivered, e.g. while the server
+// restarts, in files under dir and replays them once the server is back.
+// Events keep the time they were tracked at. A batch whose response is lost
+// may be recorded twice.
+func WithSpool(dir string) Option {
+	return func(c *Client) { c.spool = &spool{dir: dir} }
+}
+
+// New creates a client for the server at baseUrl, e.g.
+// http://localhost:3333, and starts its background sender
+func New(baseUrl string, opts ...Option) *Client {
+	c := &Client{
+		baseUrl:       strings.TrimSuffix(baseUrl, "/"),
+		httpClient:    &http.Client{Timeout: 10 * time.Second},
+		batchSize:     maxBatchSize,
+		flushInterval: 5 * time.Second,
+		maxRetries:    5,
+		retryDelay:    time.Second,
+		onError:       func(error) {},
+		events:        make(chan batchEvent, 10000),
+		flushes:       make(chan chan error),
+		done:          make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	go c.run()
+
+	return c
+}
+
+type TrackOption func(*batchEvent)
+
+// Count records n occurrences instead of one
+func Count(n int64) TrackOption {
+	return func(e *batchEvent) { e.Count = &n }
+}
+
+// Value attaches a value that is aggregated into the sum, min, max and
+// average of the event buckets
+func Value(value float64) TrackOption {
+	return func(e *batchEvent) { e.Value = &value }
+}
+
+// Dimension breaks the event down by key
+func Dimension(key string, value string) TrackOption {
+	return func(e *batchEvent) {
+		if e.Dimensions == nil {
+			e.Dimensions = make(map[string]string)
+		}
+		e.Dimensions[key] = value
+	}
+}
+
+// Track buffers an event without blocking. ErrBufferFull is returned when
+// the sender cannot keep up and the event is dropped.
+func (c *Client) Track(event string, opts ...TrackOption) error {
+	e := batchEvent{Event: event, Time: time.Now().Unix()}
+	for _, opt := range opts {
+		opt(&e)
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if c.closed {
+		return ErrClosed
+	}
+
+	select {
+	case c.events <- e:
+		return nil
+	default:
+		return ErrBufferFull
+	}
+}
+
+// Flush sends the buffered events and the spooled batches and waits until
+// they are delivered, or spooled again
+func (c *Client) Flush(ctx context.Context) error {
+	reply := make(chan error, 1)
+
+	select {
+	case c.flushes <- reply:
+	case <-c.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case err := <-reply:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown stops accepting events and sends the buffered ones. Pending
+// retries are abandoned when ctx is done.
+func (c *Client) Shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.events)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		c.cancel()
+		<-c.done
+		return ctx.Err()
+	}
+}
+
+// Close is Shutdown without a deadline
+func (c *Client) Close() error {
+	return c.Shutdown(context.Background())
+}
+
+func (c *Client) run() {
+	defer close(c.done)
+	defer c.cancel()
+
+	ticker := time.NewTicker(c.flushInterval)
+	defer ticker.Stop()
+
+	c.report(c.replay(c.ctx))
+
+	var batch []batchEvent
+	for {
+		select {
+		case e, ok := <-c.events:
+			if !ok {
+				c.report(c.send(c.ctx, batch))
+				return
+			}
+
+			batch = append(batch, e)
+			if len(batch) >= c.batchSize {
+				c.report(c.send(c.ctx, batch))
+				batch = nil
+			}
+
+		case <-ticker.C:
+			c.report(c.send(c.ctx, batch))
+			c.report(c.replay(c.ctx))
+			batch = nil
+
+		case reply := <-c.flushes:
+			batch = c.drain(batch)
+			reply <- errors.Join(c.send(c.ctx, batch), c.replay(c.ctx))
+			batch = nil
+		}
+	}
+}
+
+// drain moves the events waiting in the channel into the batch
+func (c *Client) drain(batch []batchEvent) []batchEvent {
+	for {
+		select {
+		case e, ok := <-c.events:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, e)
+		default:
+			return batch
+		}
+	}
+}
+
+func (c *Client) report(err error) {
+	if err != nil {
+		c.onError(err)
+	}
+}
+
+func (c *Client) send(ctx context.Context, events []batchEvent) error {
+	var errs []error
+	for start := 0; start < len(events); start += maxBatchSize {
+		end := min(start+maxBatchSize, len(events))
+
+		unsent, rejectedErr, err := c.sendBatch(ctx, events[start:end], c.maxRetries)
+		if len(unsent) > 0 && c.spool != nil {
+			err = c.spool.write(unsent)
+		}
+		errs = append(errs, rejectedErr, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+type rejectedError struct {
+	rejected int
+	messages []string
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("%d events rejected: %s", e.rejected, strings.Join(e.messages, ", "))
+}
+
+// isRetryable reports whether a batch failed because of the network, a rate
+// limit or a server error, rather than because of its content
+func isRetryable(err error) bool {
+	var rejectedErr *rejectedError
+	if errors.As(err, &rejectedErr) {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code == http.StatusTooManyRequests || statusErr.Code >= 500
+	}
+
+	return true
+}
+
+// sendBatch posts a batch, retrying with an exponential backoff on network
+// errors, rate limits and server errors. Only the events the server did not
+// take are sent again. The events still undelivered after the retries are
+// returned with the error that kept them back, and the events refused for
+// their content with a rejectedError.
+func (c *Client) sendBatch(ctx context.Context, events []batchEvent, maxRetries int) (unsent []batchEvent, rejected error, err error) {
+	var rejectedErr *rejectedError
+
+	delay := c.retryDelay
+	for attempt := 0; ; attempt++ {
+		var body []byte
+		body, err = json.Marshal(batchMessage{Events: events})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var result batchResult
+		var retryAfter time.Duration
+		retryAfter, err = c.do(ctx, http.MethodPost, "/api/event/batch", body, &result)
+		if err == nil {
+			if result.Rejected > 0 {
+				if rejectedErr == nil {
+					rejectedErr = &rejectedError{}
+				}
+				rejectedErr.rejected += result.Rejected
+				for _, batchErr := range result.Errors {
+					rejectedErr.messages = append(rejectedErr.messages, batchErr.Event+": "+batchErr.Message)
+				}
+			}
+
+			var limited []batchEvent
+			for _, index := range result.RateLimited {
+				if index >= 0 && index < len(events) {
+					limited = append(limited, events[index])
+				}
+			}
+			if len(limited) == 0 {
+				return nil, errOrNil(rejectedErr), nil
+			}
+
+			events = limited
+			err = &StatusError{Code: http.StatusTooManyRequests, Message: fmt.Sprintf("%d events rate limited", len(limited))}
+		}
+
+		if !isRetryable(err) {
+			return nil, errOrNil(rejectedErr), err
+		}
+		if attempt >= maxRetries {
+			return events, errOrNil(rejectedErr), err
+		}
+
+		wait := delay
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return events, errOrNil(rejectedErr), err
+		}
+		delay *= 2
+	}
+}
+
+// errOrNil keeps a nil *rejectedError from becoming a non nil error
+func errOrNil(err *rejectedError) error {
+	if err == nil {
+		return nil
+	}
+	return err
+}
+
+// StatusError is returned for responses with an error status
+type StatusError struct {
+	Code    int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("minimalytics: %d %s", e.Code, e.Message)
+}
+
+// do sends a request and decodes the data field of the response envelope
+// into data. The Retry-After delay of rate limited responses is returned.
+func (c *Client) do(ctx context.Context, method string, path string, body []byte, data any) (time.Duration, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseUrl+path, reader)
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var envelope struct {
+		Status  string          `json:"status"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+	decodeErr := json.NewDecoder(resp.Body).Decode(&envelope)
+
+	// Also set on batches where only some events were rate limited
+	var retryAfter time.Duration
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message := envelope.Message
+		if message == "" {
+			message = http.StatusText(resp.StatusCode)
+		}
+		return retryAfter, &StatusError{Code: resp.StatusCode, Message: message}
+	}
+
+	if decodeErr != nil {
+		return 0, decodeErr
+	}
+
+	if envelope.Status != "OK" {
+		return 0, errors.New(envelope.Message)
+	}
+
+	if data == nil || len(envelope.Data) == 0 {
+		return retryAfter, nil
+	}
+
+	return retryAfter, json.Unmarshal(envelope.Data, data)
+}
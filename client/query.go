@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// The types below mirror the JSON of the model package so the client can be
+// imported without the server and its sqlite dependency
+
+type TimeStat struct {
+	Time  int64 `json:"time"`
+	Count int64 `json:"count"`
+}
+
+type ValueStat struct {
+	Time    int64    `json:"time"`
+	Count   int64    `json:"count"`
+	Samples int64    `json:"samples"`
+	Sum     float64  `json:"sum"`
+	Min     *float64 `json:"min"`
+	Max     *float64 `json:"max"`
+	Avg     *float64 `json:"avg"`
+}
+
+type Graph struct {
+	Id          int64  `json:"id"`
+	DashboardId int64  `json:"dashboardId"`
+	Name        string `json:"name"`
+	Event       string `json:"event"`
+	Period      string `json:"period"`
+	Length      int64  `json:"length"`
+	CreatedOn   string `json:"createdOn"`
+	Broken      bool   `json:"broken"`
+	Unit        string `json:"unit"`
+	Position    int64  `json:"position"`
+	Row         int64  `json:"row"`
+	Column      int64  `json:"column"`
+	Width       int64  `json:"width"`
+	Height      int64  `json:"height"`
+}
+
+type Dashboard struct {
+	Id        int64  `json:"id"`
+	Name      string `json:"name"`
+	CreatedOn string `json:"createdOn"`
+}
+
+type DashboardGet struct {
+	Id        int64   `json:"id"`
+	Name      string  `json:"name"`
+	CreatedOn string  `json:"createdOn"`
+	Graphs    []Graph `json:"graphs"`
+}
+
+func (c *Client) get(ctx context.Context, path string, data any) error {
+	_, err := c.do(ctx, http.MethodGet, path, nil, data)
+	return err
+}
+
+// Stats returns the last length buckets of event for a period of DAILY,
+// HOURLY or MINUTELY, latest first
+func (c *Client) Stats(ctx context.Context, event string, period string, length int64) ([]TimeStat, error) {
+	var timeStats []TimeStat
+
+	body, err := json.Marshal(map[string]any{"event": event, "period": period, "length": length})
+	if err != nil {
+		return timeStats, err
+	}
+
+	_, err = c.do(ctx, http.MethodPost, "/api/stat/", body, &timeStats)
+	return timeStats, err
+}
+
+// Values returns the counts and aggregated values of event, see Stats
+func (c *Client) Values(ctx context.Context, event string, period string, length int64) ([]ValueStat, error) {
+	var valueStats []ValueStat
+
+	query := url.Values{"period": {period}, "length": {strconv.FormatInt(length, 10)}}
+	err := c.get(ctx, "/api/events/"+url.PathEscape(event)+"/values?"+query.Encode(), &valueStats)
+	return valueStats, err
+}
+
+func (c *Client) Dashboards(ctx context.Context) ([]Dashboard, error) {
+	var dashboards []Dashboard
+	err := c.get(ctx, "/api/dashboards/", &dashboards)
+	return dashboards, err
+}
+
+func (c *Client) Dashboard(ctx context.Context, dashboardId int64) (DashboardGet, error) {
+	var dashboard DashboardGet
+	err := c.get(ctx, "/api/dashboards/"+strconv.FormatInt(dashboardId, 10), &dashboard)
+	return dashboard, err
+}
+
+func (c *Client) Graph(ctx context.Context, graphId int64) (Graph, error) {
+	var graph Graph
+	err := c.get(ctx, "/api/graphs/"+strconv.FormatInt(graphId, 10), &graph)
+	return graph, err
+}
+
+// GraphData returns the buckets plotted by a graph, latest first
+func (c *Client) GraphData(ctx context.Context, graphId int64) ([]TimeStat, error) {
+	var timeStats []TimeStat
+	err := c.get(ctx, "/api/graphs/"+strconv.FormatInt(graphId, 10)+"/data", &timeStats)
+	return timeStats, err
+}
@@ -0,0 +1,122 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// spool is a directory holding one file per undelivered batch. File names
+// start with the time they were written at so batches replay in order.
+type spool struct {
+	dir string
+
+	mu       sync.Mutex
+	sequence int
+}
+
+func (s *spool) write(events []batchEvent) error {
+	s.mu.Lock()
+	s.sequence++
+	name := fmt.Sprintf("%019d-%06d.json", time.Now().UnixNano(), s.sequence%1000000)
+	s.mu.Unlock()
+
+	err := os.MkdirAll(s.dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	return s.save(filepath.Join(s.dir, name), events)
+}
+
+// save writes a batch under a temporary name first so a crash never leaves
+// a truncated batch to replay
+func (s *spool) save(path string, events []batchEvent) error {
+	body, err := json.Marshal(batchMessage{Events: events})
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	err = os.WriteFile(tmpPath, body, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// files returns the paths of the spooled batches, oldest first
+func (s *spool) files() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			paths = append(paths, filepath.Join(s.dir, entry.Name()))
+		}
+	}
+
+	return paths, nil
+}
+
+// replay sends the spooled batches once each, oldest first, and stops at the
+// first one the server cannot take yet. A batch the server took in part,
+// e.g. because of the rate limits, keeps only the events left to send.
+// Events rejected for their content are dropped.
+func (c *Client) replay(ctx context.Context) error {
+	if c.spool == nil {
+		return nil
+	}
+
+	paths, err := c.spool.files()
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		body, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		var batch batchMessage
+		err = json.Unmarshal(body, &batch)
+		if err != nil {
+			removeErr := os.Remove(path)
+			return fmt.Errorf("spooled batch %s: %w", filepath.Base(path), errors.Join(err, removeErr))
+		}
+
+		unsent, rejectedErr, err := c.sendBatch(ctx, batch.Events, 0)
+		if len(unsent) > 0 {
+			if len(unsent) < len(batch.Events) {
+				err = c.spool.save(path, unsent)
+			} else {
+				err = nil
+			}
+			if err == nil && rejectedErr != nil {
+				err = fmt.Errorf("spooled batch %s: %w", filepath.Base(path), rejectedErr)
+			}
+			return err
+		}
+
+		removeErr := os.Remove(path)
+		if err != nil || rejectedErr != nil || removeErr != nil {
+			return fmt.Errorf("spooled batch %s: %w", filepath.Base(path), errors.Join(rejectedErr, err, removeErr))
+		}
+	}
+
+	return nil
+}
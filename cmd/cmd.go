@@ -5,13 +5,16 @@ import (
 	"fmt"
 	"io/fs"
 	"log"
+	"minim/api"
 	"minim/model"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
+	"github.com/jxskiss/mcli"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -203,6 +206,28 @@ func CmdStatus() {
 		fmt.Println("Server is not running")
 	}
 
+	if out {
+		var rejects api.RejectStats
+		err = getApi("/api/status/", &rejects)
+		if err != nil {
+			fmt.Println("Unable to read server status:", err)
+		} else {
+			fmt.Println("Rejected events (client rate limit):", rejects.Client)
+			fmt.Println("Rejected events (event rate limit):", rejects.Event)
+			fmt.Println("Rejected events (event limit):", rejects.EventLimit)
+			fmt.Println("Rejected events (unknown event):", rejects.Unknown)
+			events := make([]string, 0, len(rejects.Events))
+			for event := range rejects.Events {
+				events = append(events, event)
+			}
+			sort.Strings(events)
+
+			for _, event := range events {
+				fmt.Printf("  %s: %d\n", event, rejects.Events[event])
+			}
+		}
+	}
+
 	minimDir, err := getMinimDir()
 
 	if err != nil {
@@ -238,3 +263,59 @@ func CmdVersion() {
 	fmt.Print("Minimalytics version: ")
 	fmt.Println(string(data))
 }
+
+func CmdConfigList() {
+	configs, err := model.GetConfigs()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// Secrets are only shown by config get
+	for _, configItem := range configs {
+		value := configItem.Value
+		if value != "" && model.IsSecretConfigKey(configItem.Key) {
+			value = "********"
+		}
+
+		fmt.Printf("%s=%s\n", configItem.Key, value)
+	}
+}
+
+func CmdConfigGet() {
+	var args struct {
+		Key string `cli:"#R, key, The config key to read"`
+	}
+	mcli.Parse(&args)
+
+	value, err := model.GetConfigValue(strings.ToUpper(args.Key))
+	if err != nil {
+		fmt.Println("Unknown config key:", args.Key)
+		return
+	}
+
+	fmt.Println(value)
+}
+
+func CmdConfigSet() {
+	var args struct {
+		Key   string `cli:"#R, key, The config key to update"`
+		Value string `cli:"value, The new value"`
+	}
+	mcli.Parse(&args)
+
+	key := strings.ToUpper(args.Key)
+	_, err := model.GetConfig(key)
+	if err != nil {
+		fmt.Println("Unknown config key:", args.Key)
+		return
+	}
+
+	err = model.SetConfig(key, args.Value)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println("Updated", key)
+}
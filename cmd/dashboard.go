@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"minim/model"
+	"os"
+
+	"github.com/jxskiss/mcli"
+)
+
+func CmdDashboardList() {
+	dashboards, err := model.GetDashboards()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, dashboard := range dashboards {
+		fmt.Printf("%d\t%s\n", dashboard.Id, dashboard.Name)
+	}
+}
+
+func CmdDashboardExport() {
+	var args struct {
+		Id  int64  `cli:"#R, id, Id of the dashboard"`
+		Out string `cli:"--out, File to write the definition to, printed when empty"`
+	}
+	mcli.Parse(&args)
+
+	definition, err := model.ExportDashboard(args.Id)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	data, err := json.MarshalIndent(definition, "", "  ")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if args.Out == "" {
+		fmt.Println(string(data))
+		return
+	}
+
+	err = os.WriteFile(args.Out, append(data, '\n'), 0644)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Printf("Exported dashboard %s to %s\n", definition.Name, args.Out)
+}
+
+func CmdDashboardImport() {
+	var args struct {
+		File    string `cli:"#R, file, Dashboard definition to import"`
+		Replace bool   `cli:"--replace, Replace the graphs of the dashboard with the same name"`
+	}
+	mcli.Parse(&args)
+
+	data, err := os.ReadFile(args.File)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	var definition model.DashboardDefinition
+	err = json.Unmarshal(data, &definition)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	dashboard, err := model.ImportDashboard(definition, args.Replace)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Printf("Imported dashboard %s with %d graphs as %d\n", dashboard.Name, len(dashboard.Graphs), dashboard.Id)
+}
+
+func CmdDashboardClone() {
+	var args struct {
+		Id   int64  `cli:"#R, id, Id of the dashboard"`
+		Name string `cli:"--name, Name of the copy, the original name followed by (copy) when empty"`
+	}
+	mcli.Parse(&args)
+
+	dashboard, err := model.CloneDashboard(args.Id, model.DashboardClone{Name: args.Name})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Printf("Cloned dashboard %d with %d graphs as %s (%d)\n", args.Id, len(dashboard.Graphs), dashboard.Name, dashboard.Id)
+}
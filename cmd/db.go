@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"minim/model"
+	"syscall"
+
+	"github.com/jxskiss/mcli"
+)
+
+func CmdDbMerge() {
+	var args struct {
+		Into       string   `cli:"#R, --into, Database file to merge into, created when missing"`
+		Dashboards bool     `cli:"--dashboards, Also copy the dashboards and graphs of the sources"`
+		Sources    []string `cli:"#R, sources, Database files to merge"`
+	}
+	mcli.Parse(&args)
+
+	results, err := model.MergeDatabases(model.DatabaseMerge{
+		Sources:    args.Sources,
+		Into:       args.Into,
+		Dashboards: args.Dashboards,
+	})
+
+	for _, result := range results {
+		fmt.Printf("%s\tevents: %d (%d new)", result.Source, result.Events, result.NewEvents)
+		if args.Dashboards {
+			fmt.Printf("\tdashboards: %d\tgraphs: %d", result.Dashboards, result.Graphs)
+		}
+		fmt.Println()
+	}
+
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println("Merged into", args.Into)
+}
+
+func CmdDbBackup() {
+	var args struct {
+		File string `cli:"#R, file, File to write the backup to"`
+	}
+	mcli.Parse(&args)
+
+	err := model.BackupDatabase(args.File)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println("Backed up the database to", args.File)
+}
+
+func CmdDbRestore() {
+	var args struct {
+		File string `cli:"#R, file, Backup to restore"`
+	}
+	mcli.Parse(&args)
+
+	// A refused connection means the PID file is stale
+	running, err := isServerRunning()
+	if err != nil && !errors.Is(err, syscall.ECONNREFUSED) {
+		fmt.Println(err)
+		return
+	}
+
+	if running {
+		fmt.Println("Server is running, stop it before restoring a backup")
+		return
+	}
+
+	err = model.RestoreDatabase(args.File)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println("Restored the database from", args.File)
+	fmt.Println("The previous database was kept as", model.DatabasePath()+".pre-restore")
+}
@@ -0,0 +1,203 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"minim/model"
+	"strings"
+	"time"
+
+	"github.com/jxskiss/mcli"
+)
+
+func CmdEventList() {
+	var args struct {
+		Sort     string `cli:"--sort, Sort by id, event, lastSeen, firstSeen or total" default:"id"`
+		Desc     bool   `cli:"--desc, Sort in descending order"`
+		Inactive string `cli:"--inactive, Only list events not seen for this long, e.g. 24h or 7d"`
+		Tag      string `cli:"--tag, Only list events with this tag"`
+	}
+	mcli.Parse(&args)
+
+	var inactive time.Duration
+	if args.Inactive != "" {
+		var err error
+		inactive, err = model.ParseDuration(args.Inactive)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	eventDefs, err := model.GetEventDefs(model.EventDefQuery{
+		Sort:        args.Sort,
+		Desc:        args.Desc,
+		InactiveFor: inactive,
+		Tag:         args.Tag,
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for _, eventDef := range eventDefs {
+		lastSeen := "never"
+		if eventDef.LastSeen != nil {
+			lastSeen = *eventDef.LastSeen
+		}
+
+		fmt.Printf("%s\ttotal: %d\tlast seen: %s\n", eventDef.Event, eventDef.Total, lastSeen)
+	}
+}
+
+func CmdEventCreate() {
+	var args struct {
+		Event string `cli:"#R, event, Name of the event to register"`
+	}
+	mcli.Parse(&args)
+
+	_, err := model.CreateEventDef(args.Event)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println("Created event", args.Event)
+}
+
+func CmdEventPending() {
+	pendingEvents, err := model.GetPendingEvents()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if len(pendingEvents) == 0 {
+		fmt.Println("No events are pending approval")
+		return
+	}
+
+	for _, pendingEvent := range pendingEvents {
+		fmt.Printf("%s\tcount: %d\tfirst seen: %s\tlast seen: %s\n",
+			pendingEvent.Event, pendingEvent.Count, pendingEvent.FirstSeen, pendingEvent.LastSeen)
+	}
+}
+
+func CmdEventApprove() {
+	var args struct {
+		Event string `cli:"#R, event, Name of the pending event"`
+	}
+	mcli.Parse(&args)
+
+	_, err := model.ApprovePendingEvent(args.Event)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println("Approved event", args.Event)
+}
+
+func CmdEventReject() {
+	var args struct {
+		Event string `cli:"#R, event, Name of the pending event"`
+	}
+	mcli.Parse(&args)
+
+	err := model.RejectPendingEvent(args.Event)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println("Rejected event", args.Event)
+}
+
+func CmdEventRename() {
+	var args struct {
+		Event string `cli:"#R, event, Name of the event to rename"`
+		Name  string `cli:"#R, name, New name of the event"`
+	}
+	mcli.Parse(&args)
+
+	_, err := model.RenameEvent(args.Event, args.Name)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Printf("Renamed event %s to %s\n", args.Event, args.Name)
+}
+
+func CmdEventMerge() {
+	var args struct {
+		Event string `cli:"#R, event, Name of the event to merge"`
+		Into  string `cli:"#R, into, Name of the event receiving the counts"`
+	}
+	mcli.Parse(&args)
+
+	_, err := model.MergeEvents(args.Event, args.Into)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Printf("Merged event %s into %s\n", args.Event, args.Into)
+}
+
+func CmdEventDelete() {
+	var args struct {
+		Event string `cli:"#R, event, Name of the event to delete"`
+	}
+	mcli.Parse(&args)
+
+	err := model.DeleteEvent(args.Event)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println("Deleted event", args.Event)
+}
+
+func CmdEventAnnotate() {
+	var args struct {
+		Event       string `cli:"#R, event, Name of the event to annotate"`
+		Description string `cli:"--description, Human readable description"`
+		Unit        string `cli:"--unit, Unit of the event values, e.g. ms, bytes or requests"`
+		Tags        string `cli:"--tags, Comma separated list of tags, replaces the current tags"`
+		Owner       string `cli:"--owner, Team owning the event"`
+	}
+	fs, err := mcli.Parse(&args)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// Only the flags given are changed, an empty value clears the field
+	var updateEvent model.EventUpdate
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "description":
+			updateEvent.Description = &args.Description
+		case "unit":
+			updateEvent.Unit = &args.Unit
+		case "tags":
+			updateEvent.Tags = strings.Split(args.Tags, ",")
+		case "owner":
+			updateEvent.Owner = &args.Owner
+		}
+	})
+
+	eventDef, err := model.UpdateEventDef(args.Event, updateEvent)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println("Event:", eventDef.Event)
+	fmt.Println("Description:", eventDef.Description)
+	fmt.Println("Unit:", eventDef.Unit)
+	fmt.Println("Tags:", strings.Join(eventDef.Tags, ", "))
+	fmt.Println("Owner:", eventDef.Owner)
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"minim/model"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jxskiss/mcli"
+)
+
+func CmdExport() {
+	var args struct {
+		Format  string `cli:"--format, json, or csv for one file per event" default:"json"`
+		Event   string `cli:"--event, Comma separated events to export, all when empty"`
+		From    string `cli:"--from, Only export buckets from this unix time or YYYY-MM-DD"`
+		To      string `cli:"--to, Only export buckets up to this unix time or YYYY-MM-DD"`
+		Out     string `cli:"--out, File to write the JSON export to, or directory of the CSV files"`
+		Secrets bool   `cli:"--secrets, Include passwords and tokens of the config"`
+	}
+	mcli.Parse(&args)
+
+	query := model.ExportQuery{Secrets: args.Secrets}
+	for _, event := range strings.Split(args.Event, ",") {
+		if event = strings.TrimSpace(event); event != "" {
+			query.Events = append(query.Events, event)
+		}
+	}
+
+	var err error
+	query.From, err = model.ParseExportTime(args.From, false)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	query.To, err = model.ParseExportTime(args.To, true)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	switch args.Format {
+	case "json":
+		export, err := model.ExportData(query)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		data, err := json.MarshalIndent(export, "", "  ")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		if args.Out == "" {
+			fmt.Println(string(data))
+			return
+		}
+
+		err = os.WriteFile(args.Out, data, 0600)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		fmt.Printf("Exported %d events to %s\n", len(export.Events), args.Out)
+
+	case "csv":
+		if args.Out == "" {
+			fmt.Println("--out is required to export CSV files")
+			return
+		}
+
+		exportEvents, err := model.ExportEvents(query)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		err = os.MkdirAll(args.Out, 0755)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+
+		for _, exportEvent := range exportEvents {
+			file, err := os.Create(filepath.Join(args.Out, exportEvent.Event+".csv"))
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+
+			err = model.WriteExportCsv(file, exportEvent)
+			file.Close()
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+		}
+
+		fmt.Printf("Exported %d events to %s\n", len(exportEvents), args.Out)
+
+	default:
+		fmt.Println("Invalid format, expected json or csv")
+	}
+}
+
+func CmdImport() {
+	var args struct {
+		File     string `cli:"#R, file, JSON export to import"`
+		Conflict string `cli:"--conflict, What to do with existing data: skip, overwrite or sum" default:"skip"`
+	}
+	mcli.Parse(&args)
+
+	data, err := os.ReadFile(args.File)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	var export model.Export
+	err = json.Unmarshal(data, &export)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	result, err := model.ImportData(export, model.ImportQuery{Conflict: args.Conflict, Config: true})
+	for _, importErr := range result.Errors {
+		fmt.Println("Skipped", importErr)
+	}
+
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Printf("Imported %d events (%d new), %d buckets, %d dashboards, %d graphs and %d config values\n",
+		result.Events, result.NewEvents, result.Buckets, result.Dashboards, result.Graphs, result.Config)
+}
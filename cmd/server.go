@@ -8,6 +8,7 @@ import (
 	"log"
 	"minim/api"
 	"minim/model"
+	"minim/notify"
 	"net/http"
 	"os"
 	"path/filepath"
@@ -82,6 +83,40 @@ func isServerRunning() (bool, error) {
 	return true, nil
 }
 
+// getApi reads an endpoint of the running server and decodes the data
+// field of the response into data
+func getApi(path string, data any) error {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+
+	port, err := model.GetConfigValue("PORT")
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Get("http://localhost:" + port + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var apiResp struct {
+		Status  string          `json:"status"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return err
+	}
+
+	if apiResp.Status != "OK" {
+		return errors.New(apiResp.Message)
+	}
+
+	return json.Unmarshal(apiResp.Data, data)
+}
+
 func stopServer() error {
 	pid, err := readPID()
 	if err != nil {
@@ -192,6 +227,26 @@ func (h spaHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	http.FileServer(http.Dir(h.staticPath)).ServeHTTP(w, r)
 }
 
+func evaluateAlerts() {
+	// Absence alerts rely on lastSeen so pending sightings are written first
+	err := model.FlushEventStats()
+	if err != nil {
+		log.Println(err)
+	}
+
+	changes, err := model.EvaluateAlerts()
+	if err != nil {
+		model.CountDbError(err)
+		log.Println(err)
+	}
+
+	for _, change := range changes {
+		log.Printf("Alert %d is %s: %s", change.AlertId, change.State, change.Message)
+	}
+
+	notify.Dispatch(changes)
+}
+
 func startServer() error {
 	minimDir, err := getMinimDir()
 	if err != nil {
@@ -221,6 +276,25 @@ func startServer() error {
 
 	// model.Init()
 	model.DeleteEvents()
+	api.InitRateLimits()
+
+	provisionDir, _ := model.GetConfigValue("DASHBOARD_PROVISION_DIR")
+	if provisionDir != "" {
+		err = model.ProvisionDashboards(provisionDir)
+		if err != nil {
+			log.Println("Unable to provision dashboards:", err)
+		}
+	}
+
+	err = api.StartStatsd()
+	if err != nil {
+		log.Println("Unable to start the StatsD listener:", err)
+	}
+
+	err = api.StartRelay()
+	if err != nil {
+		log.Println("Unable to start the relay:", err)
+	}
 
 	ticker := time.NewTicker(60 * time.Second)
 	defer ticker.Stop()
@@ -228,18 +302,48 @@ func startServer() error {
 	go func() {
 		for range ticker.C {
 			model.DeleteEvents()
+			api.PruneRateLimits()
+			evaluateAlerts()
+			notify.RunReports(time.Now())
+			model.RunBackups(time.Now())
 		}
 	}()
 
-	r := mux.NewRouter()
+	statsTicker := time.NewTicker(10 * time.Second)
+	defer statsTicker.Stop()
 
-	r.PathPrefix("/api/event/").HandlerFunc((api.Middleware(api.HandleEvent)))
+	go func() {
+		for range statsTicker.C {
+			err := model.FlushEventStats()
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}()
 
-	r.PathPrefix("/api/stat/").HandlerFunc(middleware(api.Middleware(api.HandleStat)))
-	r.PathPrefix("/api/events/").HandlerFunc(middleware(api.Middleware(api.HandleEventDefsApi)))
-	r.PathPrefix("/api/graphs/").HandlerFunc(middleware(api.Middleware(api.HandleGraphs)))
-	r.PathPrefix("/api/dashboards/").HandlerFunc(middleware(api.Middleware(api.HandleDashboard)))
-	r.PathPrefix("/api/").HandlerFunc(middleware(api.Middleware(api.HandleAPIBase)))
+	r := mux.NewRouter()
+	r.Use(api.MetricsMiddleware)
+
+	r.Path("/metrics").HandlerFunc(api.HandleMetrics)
+
+	r.Path("/api/event/minim.js").HandlerFunc(api.HandleScript)
+	r.Path("/api/event/batch").HandlerFunc(api.IngestBodyMiddleware(api.HandleEventBatch))
+	r.Path("/api/event/relay").HandlerFunc(api.IngestBodyMiddleware(api.HandleRelay))
+	r.Path("/api/event/pixel.gif").HandlerFunc(api.IngestMiddleware(api.HandlePixel))
+	r.PathPrefix("/api/event/").HandlerFunc(api.IngestMiddleware(api.HandleEvent))
+	r.Path("/v1/metrics").HandlerFunc(api.IngestMiddleware(api.HandleOtlpMetrics))
+
+	r.PathPrefix("/api/admin/").HandlerFunc(middleware(api.AdminMiddleware(api.HandleAdmin)))
+	r.PathPrefix("/api/status/").HandlerFunc(middleware(api.AdminMiddleware(api.HandleStatus)))
+	r.PathPrefix("/api/alerts/").HandlerFunc(middleware(api.AdminMiddleware(api.HandleAlerts)))
+	r.PathPrefix("/api/channels/").HandlerFunc(middleware(api.AdminMiddleware(api.HandleChannels)))
+	r.PathPrefix("/api/silences/").HandlerFunc(middleware(api.AdminMiddleware(api.HandleSilences)))
+	r.PathPrefix("/api/reports/").HandlerFunc(middleware(api.AdminMiddleware(api.HandleReports)))
+	r.PathPrefix("/api/stat/").HandlerFunc(middleware(api.AdminMiddleware(api.HandleStat)))
+	r.PathPrefix("/api/events/").HandlerFunc(middleware(api.AdminMiddleware(api.HandleEventDefs)))
+	r.PathPrefix("/api/graphs/").HandlerFunc(middleware(api.AdminMiddleware(api.HandleGraphs)))
+	r.PathPrefix("/api/dashboards/").HandlerFunc(middleware(api.AdminMiddleware(api.HandleDashboard)))
+	r.PathPrefix("/api/").HandlerFunc(middleware(api.AdminMiddleware(api.HandleAPIBase)))
 
 	spa := spaHandler{staticPath: "static", indexPath: "index.html"}
 	r.PathPrefix("/").Handler(spa)
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"minim/model"
+	"time"
+
+	"github.com/jxskiss/mcli"
+)
+
+func CmdSilenceList() {
+	silences, err := model.GetSilences()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if len(silences) == 0 {
+		fmt.Println("No silences")
+		return
+	}
+
+	for _, silence := range silences {
+		target := "event: " + silence.Event
+		if silence.Event == "" {
+			target = fmt.Sprintf("alert: %d", silence.AlertId)
+		} else if silence.AlertId != 0 {
+			target = fmt.Sprintf("event: %s, alert: %d", silence.Event, silence.AlertId)
+		}
+
+		window := fmt.Sprintf("%d minutes after '%s'", silence.Minutes, silence.Schedule)
+		if silence.Schedule == "" {
+			window = *silence.StartsAt + " to " + *silence.EndsAt
+		}
+
+		state := "inactive"
+		if silence.Active {
+			state = "active"
+		}
+
+		fmt.Printf("%d\t%s\t%s\t%s\t%s\n", silence.Id, target, window, state, silence.Comment)
+	}
+}
+
+func CmdSilenceAdd() {
+	var args struct {
+		Event    string `cli:"--event, Mute the alerts of this event"`
+		Alert    int64  `cli:"--alert, Mute this alert"`
+		For      string `cli:"--for, How long the silence lasts, e.g. 30m or 2d, at most 7d" default:"1h"`
+		Schedule string `cli:"--schedule, Cron schedule of a recurring maintenance window, e.g. '0 2 * * 0'"`
+		Comment  string `cli:"--comment, Reason for the silence"`
+	}
+	mcli.Parse(&args)
+
+	duration, err := model.ParseDuration(args.For)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	silence, err := model.CreateSilence(model.SilenceCreate{
+		Event:    args.Event,
+		AlertId:  args.Alert,
+		Comment:  args.Comment,
+		Schedule: args.Schedule,
+		Minutes:  int64(duration / time.Minute),
+	})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if silence.Schedule != "" {
+		fmt.Printf("Created silence %d for %d minutes after '%s'\n", silence.Id, silence.Minutes, silence.Schedule)
+		return
+	}
+
+	fmt.Printf("Created silence %d until %s\n", silence.Id, *silence.EndsAt)
+}
+
+func CmdSilenceDelete() {
+	var args struct {
+		Id int64 `cli:"#R, id, Id of the silence to delete"`
+	}
+	mcli.Parse(&args)
+
+	err := model.DeleteSilence(args.Id)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	fmt.Println("Deleted silence", args.Id)
+}
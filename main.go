@@ -23,6 +23,41 @@ func main() {
 	mcli.Add("web enable", cmd.CmdUiEnable, "Enable the Minim UI")
 	mcli.Add("web disable", cmd.CmdUiDisable, "Disable the Minim UI")
 
+	mcli.AddGroup("event", "Commands for managing events")
+	mcli.Add("event list", cmd.CmdEventList, "List all events")
+	mcli.Add("event create", cmd.CmdEventCreate, "Register a new event")
+	mcli.Add("event pending", cmd.CmdEventPending, "List events waiting for approval")
+	mcli.Add("event approve", cmd.CmdEventApprove, "Approve a pending event")
+	mcli.Add("event reject", cmd.CmdEventReject, "Reject a pending event")
+	mcli.Add("event rename", cmd.CmdEventRename, "Rename an event and its data")
+	mcli.Add("event merge", cmd.CmdEventMerge, "Merge the counts of an event into another")
+	mcli.Add("event delete", cmd.CmdEventDelete, "Delete an event and its data")
+	mcli.Add("event annotate", cmd.CmdEventAnnotate, "Set the description, unit, tags and owner of an event")
+
+	mcli.AddGroup("dashboard", "Commands for managing dashboards")
+	mcli.Add("dashboard list", cmd.CmdDashboardList, "List all dashboards")
+	mcli.Add("dashboard export", cmd.CmdDashboardExport, "Export a dashboard as a portable definition")
+	mcli.Add("dashboard import", cmd.CmdDashboardImport, "Import a dashboard definition")
+	mcli.Add("dashboard clone", cmd.CmdDashboardClone, "Copy a dashboard with all its graphs")
+
+	mcli.AddGroup("silence", "Commands for muting alert notifications")
+	mcli.Add("silence list", cmd.CmdSilenceList, "List silences and maintenance windows")
+	mcli.Add("silence add", cmd.CmdSilenceAdd, "Mute the notifications of an event or alert")
+	mcli.Add("silence delete", cmd.CmdSilenceDelete, "Delete a silence")
+
+	mcli.AddGroup("db", "Commands for managing database files")
+	mcli.Add("db merge", cmd.CmdDbMerge, "Merge database files into one")
+	mcli.Add("db backup", cmd.CmdDbBackup, "Write a consistent snapshot of the database")
+	mcli.Add("db restore", cmd.CmdDbRestore, "Replace the database with a backup")
+
+	mcli.Add("export", cmd.CmdExport, "Export events, buckets, dashboards and config")
+	mcli.Add("import", cmd.CmdImport, "Import an export")
+
+	mcli.AddGroup("config", "Commands for managing Minimalytics configuration")
+	mcli.Add("config list", cmd.CmdConfigList, "List all config values")
+	mcli.Add("config get", cmd.CmdConfigGet, "Read a config value")
+	mcli.Add("config set", cmd.CmdConfigSet, "Update a config value")
+
 	mcli.AddHidden("execserver", cmd.CmdExecServer, "")
 
 	mcli.Run()
@@ -0,0 +1,890 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Alert types
+const (
+	AlertAbsence   = "ABSENCE"
+	AlertFloor     = "FLOOR"
+	AlertThreshold = "THRESHOLD"
+	AlertChange    = "CHANGE"
+)
+
+// Alert states
+const (
+	AlertPending  = "PENDING"
+	AlertFiring   = "FIRING"
+	AlertResolved = "RESOLVED"
+)
+
+// Evaluation history older than this is trimmed
+const alertEvaluationRetention = 7 * 24 * time.Hour
+
+type Alert struct {
+	Id         int64   `json:"id"`
+	Name       string  `json:"name"`
+	Event      string  `json:"event"`
+	Type       string  `json:"type"`
+	Period     string  `json:"period"`
+	Minutes    int64   `json:"minutes"`
+	Threshold  float64 `json:"threshold"`
+	State      string  `json:"state"`
+	StateSince string  `json:"stateSince"`
+	CreatedOn  string  `json:"createdOn"`
+
+	Op               string   `json:"op"`
+	For              int64    `json:"for"`
+	Offset           int64    `json:"offset"`
+	ResolveThreshold *float64 `json:"resolveThreshold"`
+
+	// Channels notified of the alert, every channel when empty
+	Channels []int64 `json:"channels"`
+
+	// Last state receivers were notified of, empty until the alert first fires
+	NotifiedState string `json:"notifiedState"`
+}
+
+type AlertCreate struct {
+	Name             string   `json:"name"`
+	Event            string   `json:"event"`
+	Type             string   `json:"type"`
+	Period           string   `json:"period"`
+	Minutes          int64    `json:"minutes"`
+	Threshold        float64  `json:"threshold"`
+	Op               string   `json:"op"`
+	For              int64    `json:"for"`
+	Offset           int64    `json:"offset"`
+	ResolveThreshold *float64 `json:"resolveThreshold"`
+	Channels         []int64  `json:"channels"`
+}
+
+// AlertUpdate changes the fields that are set, an empty Channels list
+// notifies every channel again
+type AlertUpdate struct {
+	Name             string   `json:"name"`
+	Period           string   `json:"period"`
+	Minutes          int64    `json:"minutes"`
+	Threshold        float64  `json:"threshold"`
+	Op               string   `json:"op"`
+	For              int64    `json:"for"`
+	Offset           int64    `json:"offset"`
+	ResolveThreshold *float64 `json:"resolveThreshold"`
+	Channels         []int64  `json:"channels"`
+}
+
+type AlertStateChange struct {
+	Id            int64   `json:"id"`
+	AlertId       int64   `json:"alertId"`
+	PreviousState string  `json:"previousState"`
+	State         string  `json:"state"`
+	Value         float64 `json:"value"`
+	Message       string  `json:"message"`
+	Silenced      bool    `json:"silenced"`
+	CreatedOn     string  `json:"createdOn"`
+
+	// Notify is set when receivers have to hear about the change. A change
+	// made during a silence is notified once the silence ends, with an Id
+	// of 0 as the state itself did not change then.
+	Notify bool `json:"-"`
+}
+
+type AlertEvaluation struct {
+	Id        int64   `json:"id"`
+	AlertId   int64   `json:"alertId"`
+	State     string  `json:"state"`
+	Value     float64 `json:"value"`
+	Holding   bool    `json:"holding"`
+	Silenced  bool    `json:"silenced"`
+	CreatedOn string  `json:"createdOn"`
+}
+
+const alertColumns = "id, name, event, type, period, minutes, threshold, state, stateSince, createdOn, op, forBuckets, offset, resolveThreshold, notifiedState, channels"
+
+func InitAlerts() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS alerts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT,
+			event TEXT,
+			type TEXT,
+			period TEXT,
+			minutes INTEGER,
+			threshold REAL,
+			state TEXT,
+			stateSince TEXT,
+			createdOn TEXT,
+			op TEXT NOT NULL DEFAULT '',
+			forBuckets INTEGER NOT NULL DEFAULT 1,
+			offset INTEGER NOT NULL DEFAULT 0,
+			resolveThreshold REAL,
+			notifiedState TEXT NOT NULL DEFAULT '',
+			channels TEXT NOT NULL DEFAULT ''
+		);`
+
+	_, err := db.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		CREATE TABLE IF NOT EXISTS alert_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			alertId INTEGER NOT NULL,
+			state TEXT,
+			value REAL,
+			message TEXT,
+			createdOn TEXT,
+			previousState TEXT NOT NULL DEFAULT '',
+			silenced INTEGER NOT NULL DEFAULT 0
+		);`
+
+	_, err = db.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		CREATE TABLE IF NOT EXISTS alert_evaluations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			alertId INTEGER NOT NULL,
+			state TEXT,
+			value REAL,
+			holding INTEGER,
+			createdOn TEXT,
+			silenced INTEGER NOT NULL DEFAULT 0
+		);`
+
+	_, err = db.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	return migrateAlerts()
+}
+
+// migrateAlerts adds the rule and silence columns to alert tables created
+// before they existed
+func migrateAlerts() error {
+	notifiedExists, err := columnExists("alerts", "notifiedState")
+	if err != nil {
+		return err
+	}
+
+	columns := []struct {
+		table      string
+		column     string
+		definition string
+	}{
+		{"alerts", "op", "TEXT NOT NULL DEFAULT ''"},
+		{"alerts", "forBuckets", "INTEGER NOT NULL DEFAULT 1"},
+		{"alerts", "offset", "INTEGER NOT NULL DEFAULT 0"},
+		{"alerts", "resolveThreshold", "REAL"},
+		{"alert_history", "previousState", "TEXT NOT NULL DEFAULT ''"},
+		{"alert_history", "silenced", "INTEGER NOT NULL DEFAULT 0"},
+		{"alert_evaluations", "silenced", "INTEGER NOT NULL DEFAULT 0"},
+		{"alerts", "notifiedState", "TEXT NOT NULL DEFAULT ''"},
+		{"alerts", "channels", "TEXT NOT NULL DEFAULT ''"},
+	}
+
+	for _, column := range columns {
+		exists, err := columnExists(column.table, column.column)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", column.table, column.column, column.definition)
+			_, err = db.Exec(query)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Alerts firing before notified states were tracked have been notified
+	if !notifiedExists {
+		_, err = db.Exec("update alerts set notifiedState = state where state = ?", AlertFiring)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = db.Exec("update alerts set op = '>=' where type = ? and op = ''", AlertAbsence)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("update alerts set op = '<' where type = ? and op = ''", AlertFloor)
+	if err != nil {
+		return err
+	}
+
+	// Earlier versions accepted negative for and offset values
+	_, err = db.Exec("update alerts set forBuckets = 1 where forBuckets < 1")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("update alerts set offset = 0 where type != ? and offset != 0", AlertChange)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("update alerts set offset = 1 where type = ? and offset < 1", AlertChange)
+	return err
+}
+
+func scanAlert(row rowScanner) (Alert, error) {
+	var alert Alert
+	var channels string
+	err := row.Scan(&alert.Id, &alert.Name, &alert.Event, &alert.Type, &alert.Period, &alert.Minutes,
+		&alert.Threshold, &alert.State, &alert.StateSince, &alert.CreatedOn,
+		&alert.Op, &alert.For, &alert.Offset, &alert.ResolveThreshold, &alert.NotifiedState, &channels)
+
+	alert.Channels = splitChannelIds(channels)
+	return alert, err
+}
+
+// Alert channels are stored as a comma separated list of ids
+func splitChannelIds(channels string) []int64 {
+	channelIds := []int64{}
+	for _, channel := range strings.Split(channels, ",") {
+		channelId, err := strconv.ParseInt(strings.TrimSpace(channel), 10, 64)
+		if err == nil {
+			channelIds = append(channelIds, channelId)
+		}
+	}
+
+	return channelIds
+}
+
+func joinChannelIds(channelIds []int64) string {
+	var channels []string
+	for _, channelId := range channelIds {
+		channels = append(channels, strconv.FormatInt(channelId, 10))
+	}
+
+	return strings.Join(channels, ",")
+}
+
+// validateAlertChannels checks that the channels of an alert exist
+func validateAlertChannels(channelIds []int64) error {
+	for _, channelId := range channelIds {
+		_, err := GetChannel(channelId)
+		if err != nil {
+			return fmt.Errorf("Invalid channel %d", channelId)
+		}
+	}
+
+	return nil
+}
+
+// NotifiesChannel reports whether changes of the alert are sent to the
+// channel
+func (alert Alert) NotifiesChannel(channelId int64) bool {
+	return len(alert.Channels) == 0 || slices.Contains(alert.Channels, channelId)
+}
+
+func GetAlerts() ([]Alert, error) {
+	var alerts []Alert
+
+	rows, err := db.Query("select " + alertColumns + " from alerts")
+	if err != nil {
+		return alerts, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		alert, err := scanAlert(rows)
+		if err != nil {
+			return alerts, err
+		}
+		alerts = append(alerts, alert)
+	}
+
+	return alerts, nil
+}
+
+func GetAlert(alertId int64) (Alert, error) {
+	row := db.QueryRow("select "+alertColumns+" from alerts where id = ?", alertId)
+
+	alert, err := scanAlert(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return alert, errors.New("Invalid alertId")
+		}
+	}
+
+	return alert, err
+}
+
+func isValidPeriod(period string) bool {
+	return period == "DAILY" || period == "HOURLY" || period == "MINUTELY"
+}
+
+func isValidOp(op string) bool {
+	return op == ">" || op == ">=" || op == "<" || op == "<="
+}
+
+func compareValue(value float64, op string, threshold float64) bool {
+	switch op {
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	}
+
+	return false
+}
+
+// normalizeAlert fills in the parts of a rule that are implied by its type
+func normalizeAlert(alert *Alert) {
+	switch alert.Type {
+	case AlertAbsence:
+		alert.Op = ">="
+		alert.For = 1
+
+	case AlertFloor:
+		alert.Op = "<"
+	}
+
+	if alert.For == 0 {
+		alert.For = 1
+	}
+
+	// Only change rules compare against an earlier bucket
+	if alert.Type != AlertChange {
+		alert.Offset = 0
+	} else if alert.Offset == 0 {
+		alert.Offset = 1
+	}
+}
+
+
+// validateResolveThreshold checks that the resolve threshold of a rule is
+// on the resolving side of the threshold it fires at
+func validateResolveThreshold(alert Alert, threshold float64) error {
+	if alert.ResolveThreshold == nil {
+		return nil
+	}
+
+	resolveThreshold := *alert.ResolveThreshold
+	if (alert.Op == ">" || alert.Op == ">=") && resolveThreshold > threshold ||
+		(alert.Op == "<" || alert.Op == "<=") && resolveThreshold < threshold {
+		return errors.New("Invalid resolveThreshold value")
+	}
+
+	return nil
+}
+
+func validateAlert(alert Alert) error {
+	if alert.Name == "" {
+		return errors.New("Invalid name")
+	}
+
+	switch alert.Type {
+	case AlertAbsence:
+		if alert.Minutes <= 0 {
+			return errors.New("Invalid minutes value")
+		}
+
+		return validateResolveThreshold(alert, float64(alert.Minutes))
+
+	case AlertFloor:
+		if alert.Threshold <= 0 {
+			return errors.New("Invalid threshold value")
+		}
+
+	case AlertThreshold, AlertChange:
+		if !isValidOp(alert.Op) {
+			return errors.New("Invalid op value")
+		}
+
+	default:
+		return errors.New("Invalid alert type")
+	}
+
+	if !isValidPeriod(alert.Period) {
+		return errors.New("Invalid period value")
+	}
+
+	if alert.For < 0 || alert.Offset < 0 || alert.For > 60 || alert.Offset > 60 {
+		return errors.New("Invalid for or offset value")
+	}
+
+	// Buckets older than the retention of the period read as empty
+	limit, ok := periodBuckets[alert.Period]
+	if ok && alert.For+alert.Offset+1 > limit {
+		return fmt.Errorf("For and offset cannot reach back more than %d %s buckets", limit-1, alert.Period)
+	}
+
+	return validateResolveThreshold(alert, alert.Threshold)
+}
+
+func CreateAlert(createAlert AlertCreate) (Alert, error) {
+	alert := Alert{
+		Name:      createAlert.Name,
+		Event:     createAlert.Event,
+		Type:      createAlert.Type,
+		Period:    createAlert.Period,
+		Minutes:   createAlert.Minutes,
+		Threshold: createAlert.Threshold,
+
+		Op:               createAlert.Op,
+		For:              createAlert.For,
+		Offset:           createAlert.Offset,
+		ResolveThreshold: createAlert.ResolveThreshold,
+		Channels:         createAlert.Channels,
+	}
+
+	exists, _ := IsValidEvent(alert.Event)
+	if !exists {
+		return alert, errors.New("Invalid event value")
+	}
+
+	normalizeAlert(&alert)
+	err := validateAlert(alert)
+	if err != nil {
+		return alert, err
+	}
+
+	err = validateAlertChannels(alert.Channels)
+	if err != nil {
+		return alert, err
+	}
+
+	currentTime := time.Now()
+	formattedTime := currentTime.Format("2006-01-02 15:04:05")
+
+	result, err := db.Exec(
+		`
+		INSERT INTO alerts (name, event, type, period, minutes, threshold, state, stateSince, createdOn,
+			op, forBuckets, offset, resolveThreshold, channels)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`,
+		alert.Name, alert.Event, alert.Type, alert.Period, alert.Minutes, alert.Threshold,
+		AlertResolved, formattedTime, formattedTime,
+		alert.Op, alert.For, alert.Offset, alert.ResolveThreshold, joinChannelIds(alert.Channels))
+	if err != nil {
+		return alert, err
+	}
+
+	alertId, err := result.LastInsertId()
+	if err != nil {
+		return alert, err
+	}
+
+	return GetAlert(alertId)
+}
+
+func UpdateAlert(alertId int64, updateAlert AlertUpdate) (Alert, error) {
+	alert, err := GetAlert(alertId)
+	if err != nil {
+		return alert, err
+	}
+
+	if updateAlert.Name != "" {
+		alert.Name = updateAlert.Name
+	}
+
+	if updateAlert.Period != "" {
+		alert.Period = updateAlert.Period
+	}
+
+	if updateAlert.Minutes != 0 {
+		alert.Minutes = updateAlert.Minutes
+	}
+
+	if updateAlert.Threshold != 0 {
+		alert.Threshold = updateAlert.Threshold
+	}
+
+	if updateAlert.Op != "" {
+		alert.Op = updateAlert.Op
+	}
+
+	if updateAlert.For != 0 {
+		alert.For = updateAlert.For
+	}
+
+	if updateAlert.Offset != 0 {
+		alert.Offset = updateAlert.Offset
+	}
+
+	if updateAlert.ResolveThreshold != nil {
+		alert.ResolveThreshold = updateAlert.ResolveThreshold
+	}
+
+	if updateAlert.Channels != nil {
+		alert.Channels = updateAlert.Channels
+	}
+
+	normalizeAlert(&alert)
+	err = validateAlert(alert)
+	if err != nil {
+		return alert, err
+	}
+
+	err = validateAlertChannels(alert.Channels)
+	if err != nil {
+		return alert, err
+	}
+
+	_, err = db.Exec(`
+		UPDATE alerts
+		set name = ?, period = ?, minutes = ?, threshold = ?, op = ?, forBuckets = ?, offset = ?, resolveThreshold = ?,
+			channels = ?
+		where id = ?`,
+		alert.Name, alert.Period, alert.Minutes, alert.Threshold,
+		alert.Op, alert.For, alert.Offset, alert.ResolveThreshold, joinChannelIds(alert.Channels), alertId)
+	if err != nil {
+		return alert, err
+	}
+
+	return GetAlert(alertId)
+}
+
+func DeleteAlert(alertId int64) error {
+	_, err := GetAlert(alertId)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM alert_history where alertId = ?", alertId)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM alert_evaluations where alertId = ?", alertId)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM silences where alertId = ?", alertId)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM alerts where id = ?", alertId)
+	return err
+}
+
+func GetAlertHistory(alertId int64) ([]AlertStateChange, error) {
+	var history []AlertStateChange
+
+	_, err := GetAlert(alertId)
+	if err != nil {
+		return history, err
+	}
+
+	rows, err := db.Query(`
+		select id, alertId, previousState, state, value, message, silenced, createdOn
+		from alert_history where alertId = ? order by id desc`, alertId)
+	if err != nil {
+		return history, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var change AlertStateChange
+		err := rows.Scan(&change.Id, &change.AlertId, &change.PreviousState, &change.State, &change.Value, &change.Message, &change.Silenced, &change.CreatedOn)
+		if err != nil {
+			return history, err
+		}
+		history = append(history, change)
+	}
+
+	return history, nil
+}
+
+func GetAlertEvaluations(alertId int64) ([]AlertEvaluation, error) {
+	var evaluations []AlertEvaluation
+
+	_, err := GetAlert(alertId)
+	if err != nil {
+		return evaluations, err
+	}
+
+	rows, err := db.Query(`
+		select id, alertId, state, value, holding, silenced, createdOn
+		from alert_evaluations where alertId = ? order by id desc limit 1000`, alertId)
+	if err != nil {
+		return evaluations, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var evaluation AlertEvaluation
+		err := rows.Scan(&evaluation.Id, &evaluation.AlertId, &evaluation.State, &evaluation.Value, &evaluation.Holding, &evaluation.Silenced, &evaluation.CreatedOn)
+		if err != nil {
+			return evaluations, err
+		}
+		evaluations = append(evaluations, evaluation)
+	}
+
+	return evaluations, nil
+}
+
+// changePercent returns the change of current against previous, there is
+// no change to measure against an empty bucket
+func changePercent(current int64, previous int64) (float64, bool) {
+	if previous == 0 {
+		return 0, false
+	}
+
+	return float64(current-previous) / float64(previous) * 100, true
+}
+
+// alertValues returns the values an alert is checked against for the last
+// alert.For buckets, most recent first. The current bucket is still filling
+// up so only complete buckets are used. A change rule has values up to the
+// first bucket without a baseline, none when the latest one has no data.
+func alertValues(alert Alert, now time.Time) ([]float64, error) {
+	var values []float64
+
+	// Rules stored by earlier versions may not be valid anymore
+	err := validateAlert(alert)
+	if err != nil {
+		return values, err
+	}
+
+	if alert.Type == AlertAbsence {
+		eventDef, err := GetEventDef(alert.Event)
+		if err != nil {
+			return values, err
+		}
+
+		// An event that was never seen is measured from the creation of the alert
+		seenText := alert.CreatedOn
+		if eventDef.LastSeen != nil {
+			seenText = *eventDef.LastSeen
+		}
+
+		lastSeen, err := time.ParseInLocation("2006-01-02 15:04:05", seenText, time.Local)
+		if err != nil {
+			return values, err
+		}
+
+		return append(values, now.Sub(lastSeen).Minutes()), nil
+	}
+
+	stats, err := GetEventData(alert.Event, alert.Period, alert.For+alert.Offset+1)
+	if err != nil {
+		return values, err
+	}
+
+	for i := 1; i <= int(alert.For); i++ {
+		if alert.Type == AlertChange {
+			change, ok := changePercent(stats[i].Count, stats[i+int(alert.Offset)].Count)
+			if !ok {
+				break
+			}
+			values = append(values, change)
+		} else {
+			values = append(values, float64(stats[i].Count))
+		}
+	}
+
+	return values, nil
+}
+
+func alertMessage(alert Alert, value float64) string {
+	switch alert.Type {
+	case AlertAbsence:
+		return fmt.Sprintf("%s not seen for %.0f minutes", alert.Event, value)
+
+	case AlertChange:
+		return fmt.Sprintf("%s %s count changed %.1f%% over %d periods, rule is %s %g%%",
+			alert.Event, alert.Period, value, alert.Offset, alert.Op, alert.Threshold)
+	}
+
+	return fmt.Sprintf("%s count %.0f for the last %s period, rule is %s %g",
+		alert.Event, value, alert.Period, alert.Op, alert.Threshold)
+}
+
+// nextAlertState applies the for duration and hysteresis of a rule. A rule
+// fires once its condition held for alert.For buckets in a row and stays
+// firing until the latest value no longer passes the resolve threshold.
+func nextAlertState(alert Alert, values []float64) (string, bool) {
+	threshold := alert.Threshold
+	if alert.Type == AlertAbsence {
+		threshold = float64(alert.Minutes)
+	}
+
+	holding := compareValue(values[0], alert.Op, threshold)
+
+	if alert.State == AlertFiring {
+		resolveThreshold := threshold
+		if alert.ResolveThreshold != nil {
+			resolveThreshold = *alert.ResolveThreshold
+		}
+
+		if compareValue(values[0], alert.Op, resolveThreshold) {
+			return AlertFiring, holding
+		}
+
+		return AlertResolved, holding
+	}
+
+	if !holding {
+		return AlertResolved, holding
+	}
+
+	for _, value := range values {
+		if !compareValue(value, alert.Op, threshold) {
+			return AlertPending, holding
+		}
+	}
+
+	if len(values) < int(alert.For) {
+		return AlertPending, holding
+	}
+
+	return AlertFiring, holding
+}
+
+func recordAlertEvaluation(alert Alert, state string, value float64, holding bool, silenced bool, now time.Time) error {
+	formattedTime := now.Format("2006-01-02 15:04:05")
+
+	_, err := db.Exec(`
+		INSERT INTO alert_evaluations (alertId, state, value, holding, silenced, createdOn)
+		values (?, ?, ?, ?, ?, ?)`,
+		alert.Id, state, value, holding, silenced, formattedTime)
+
+	return err
+}
+
+func setAlertState(alert Alert, state string, value float64, message string, silenced bool, now time.Time) (AlertStateChange, error) {
+	formattedTime := now.Format("2006-01-02 15:04:05")
+
+	change := AlertStateChange{
+		AlertId:       alert.Id,
+		PreviousState: alert.State,
+		State:         state,
+		Value:         value,
+		Message:       message,
+		Silenced:      silenced,
+		CreatedOn:     formattedTime,
+	}
+
+	_, err := db.Exec("update alerts set state = ?, stateSince = ? where id = ?", state, formattedTime, alert.Id)
+	if err != nil {
+		return change, err
+	}
+
+	result, err := db.Exec(`
+		INSERT INTO alert_history (alertId, previousState, state, value, message, silenced, createdOn)
+		values (?, ?, ?, ?, ?, ?, ?)`,
+		alert.Id, alert.State, state, value, message, silenced, formattedTime)
+	if err != nil {
+		return change, err
+	}
+
+	change.Id, err = result.LastInsertId()
+	return change, err
+}
+
+// EvaluateAlerts checks every alert and records the ones that changed state,
+// the changes are returned so they can be notified. Alerts muted by a silence
+// are still evaluated, their changes are recorded and flagged as silenced.
+func EvaluateAlerts() ([]AlertStateChange, error) {
+	var changes []AlertStateChange
+
+	alerts, err := GetAlerts()
+	if err != nil {
+		return changes, err
+	}
+
+	now := time.Now()
+	for _, alert := range alerts {
+		values, err := alertValues(alert, now)
+		if err != nil {
+			log.Printf("Unable to evaluate alert %d: %v", alert.Id, err)
+			continue
+		}
+
+		// Without data the alert keeps its state
+		if len(values) == 0 {
+			continue
+		}
+
+		state, holding := nextAlertState(alert, values)
+
+		silenced, err := IsAlertSilenced(alert, now)
+		if err != nil {
+			return changes, err
+		}
+
+		err = recordAlertEvaluation(alert, state, values[0], holding, silenced, now)
+		if err != nil {
+			return changes, err
+		}
+
+		// Receivers hear about firing and resolving, pending alerts and
+		// alerts resolving without having been notified as firing stay quiet
+		notifiedState := alert.NotifiedState
+		if notifiedState == "" {
+			notifiedState = AlertResolved
+		}
+		notify := !silenced && state != AlertPending && state != notifiedState
+
+		if state == alert.State && !notify {
+			continue
+		}
+
+		message := alertMessage(alert, values[0])
+
+		change := AlertStateChange{
+			AlertId:       alert.Id,
+			PreviousState: notifiedState,
+			State:         state,
+			Value:         values[0],
+			Message:       message,
+			CreatedOn:     now.Format("2006-01-02 15:04:05"),
+		}
+
+		if state != alert.State {
+			change, err = setAlertState(alert, state, values[0], message, silenced, now)
+			if err != nil {
+				return changes, err
+			}
+		}
+
+		if notify {
+			change.Notify = true
+			_, err = db.Exec("update alerts set notifiedState = ? where id = ?", state, alert.Id)
+			if err != nil {
+				return changes, err
+			}
+		}
+
+		changes = append(changes, change)
+	}
+
+	cutoff := now.Add(-alertEvaluationRetention)
+	_, err = db.Exec("delete from alert_evaluations where createdOn < ?", cutoff.Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return changes, err
+	}
+
+	err = PruneDeliveries(cutoff)
+	if err != nil {
+		return changes, err
+	}
+
+	err = DeleteExpiredSilences(cutoff)
+	return changes, err
+}
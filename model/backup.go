@@ -0,0 +1,275 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Scheduled backups are named after the time they were taken at, e.g.
+// minim-20240131-020000.db
+const backupPrefix = "minim-"
+const backupSuffix = ".db"
+const backupTimeFormat = "20060102-150405"
+
+var backupRunning atomic.Bool
+
+// BackupDatabase writes a consistent snapshot of the database to path. The
+// database stays available for writes while the snapshot is taken.
+func BackupDatabase(path string) error {
+	ok, err := exists(path)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return errors.New("File already exists")
+	}
+
+	// Written under a temporary name so an interrupted backup is never
+	// mistaken for a complete one
+	tmpPath := path + ".tmp"
+	os.Remove(tmpPath)
+
+	_, err = db.Exec("VACUUM INTO ?", tmpPath)
+	if err != nil {
+		CountDbError(err)
+		os.Remove(tmpPath)
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// backupDir returns BACKUP_DIR, ~/.minim/backups when it is not set
+func backupDir() string {
+	dir, _ := GetConfigValue("BACKUP_DIR")
+	if dir == "" {
+		dir = filepath.Join(filepath.Dir(DatabasePath()), "backups")
+	}
+
+	return dir
+}
+
+// listBackups returns the scheduled backups in dir with the time they were
+// taken at, oldest first as the names sort by time
+func listBackups(dir string) ([]string, []time.Time, error) {
+	var paths []string
+	var times []time.Time
+
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return paths, times, nil
+	}
+	if err != nil {
+		return paths, times, err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupSuffix) {
+			continue
+		}
+
+		backupTime, err := time.ParseInLocation(backupTimeFormat,
+			strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupSuffix), time.Local)
+		if err != nil {
+			continue
+		}
+
+		paths = append(paths, filepath.Join(dir, name))
+		times = append(times, backupTime)
+	}
+
+	return paths, times, nil
+}
+
+// RunBackups takes a backup into the backup directory when BACKUP_SCHEDULE
+// came up since the latest one and deletes the backups beyond BACKUP_KEEP,
+// it is called every minute by the server
+func RunBackups(now time.Time) {
+	expression, _ := GetConfigValue("BACKUP_SCHEDULE")
+	if expression == "" {
+		return
+	}
+
+	schedule, err := ParseSchedule(expression)
+	if err != nil {
+		log.Println("Invalid BACKUP_SCHEDULE:", err)
+		return
+	}
+
+	dir := backupDir()
+	_, times, err := listBackups(dir)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	since := now.Add(-time.Minute)
+	if len(times) > 0 {
+		since = times[len(times)-1]
+	}
+
+	next := schedule.Next(since)
+	if next.IsZero() || next.After(now) {
+		return
+	}
+
+	// A slow backup must not be started again by the next tick
+	if !backupRunning.CompareAndSwap(false, true) {
+		return
+	}
+
+	go func() {
+		defer backupRunning.Store(false)
+
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			log.Println("Unable to create the backup directory:", err)
+			return
+		}
+
+		path := filepath.Join(dir, backupPrefix+now.Format(backupTimeFormat)+backupSuffix)
+		err = BackupDatabase(path)
+		if err != nil {
+			log.Println("Unable to back up the database:", err)
+			return
+		}
+
+		log.Println("Backed up the database to", path)
+
+		keepConfig, _ := GetConfigValue("BACKUP_KEEP")
+		keep, err := strconv.Atoi(keepConfig)
+		if err != nil || keep < 1 {
+			return
+		}
+
+		err = rotateBackups(dir, keep)
+		if err != nil {
+			log.Println("Unable to delete old backups:", err)
+		}
+	}()
+}
+
+// rotateBackups deletes the oldest scheduled backups in dir beyond keep
+func rotateBackups(dir string, keep int) error {
+	paths, _, err := listBackups(dir)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(paths)-keep; i++ {
+		err = os.Remove(paths[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkBackup verifies that the file at path is an intact Minimalytics
+// database
+func checkBackup(path string) error {
+	backupDb, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer backupDb.Close()
+
+	var result string
+	err = backupDb.QueryRow("PRAGMA quick_check").Scan(&result)
+	if err != nil {
+		return err
+	}
+	if result != "ok" {
+		return errors.New("Backup is corrupted: " + result)
+	}
+
+	var isMinim bool
+	err = backupDb.QueryRow("select exists (select 1 from sqlite_master where type = 'table' and name = 'events')").Scan(&isMinim)
+	if err != nil {
+		return err
+	}
+	if !isMinim {
+		return errors.New("Not a Minimalytics database")
+	}
+
+	return nil
+}
+
+func copyFile(source string, path string) error {
+	sourceFile, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, sourceFile)
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	err = file.Sync()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// RestoreDatabase replaces the database with the backup at path. The
+// server must be stopped. The replaced database is kept as
+// data.db.pre-restore and the package has to be initialised again.
+func RestoreDatabase(path string) error {
+	err := checkBackup(path)
+	if err != nil {
+		return err
+	}
+
+	dbPath := DatabasePath()
+	tmpPath := dbPath + ".restore"
+
+	err = copyFile(path, tmpPath)
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if db != nil {
+		db.Close()
+		didInit = false
+	}
+
+	ok, err := exists(dbPath)
+	if err != nil {
+		return err
+	}
+	if ok {
+		err = os.Rename(dbPath, dbPath+".pre-restore")
+		if err != nil {
+			return err
+		}
+	}
+
+	// Journals of the replaced database must not be applied to the backup
+	for _, suffix := range []string{"-journal", "-wal", "-shm"} {
+		os.Remove(dbPath + suffix)
+	}
+
+	return os.Rename(tmpPath, dbPath)
+}
@@ -0,0 +1,342 @@
+package model
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/mail"
+	"net/url"
+	"text/template"
+	"time"
+)
+
+// Channel types
+const (
+	ChannelWebhook = "WEBHOOK"
+	ChannelEmail   = "EMAIL"
+)
+
+// Webhook payload formats
+const (
+	FormatGeneric = "GENERIC"
+	FormatSlack   = "SLACK"
+	FormatCustom  = "CUSTOM"
+)
+
+type Channel struct {
+	Id        int64  `json:"id"`
+	Name      string `json:"name"`
+	Type      string `json:"type"`
+	Target    string `json:"target"`
+	Secret    string `json:"-"`
+	Format    string `json:"format"`
+	Template  string `json:"template"`
+	CreatedOn string `json:"createdOn"`
+}
+
+type ChannelCreate struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Target   string `json:"target"`
+	Secret   string `json:"secret"`
+	Format   string `json:"format"`
+	Template string `json:"template"`
+}
+
+type ChannelUpdate struct {
+	Name     string `json:"name"`
+	Target   string `json:"target"`
+	Secret   string `json:"secret"`
+	Format   string `json:"format"`
+	Template string `json:"template"`
+}
+
+type Delivery struct {
+	Id         int64  `json:"id"`
+	ChannelId  int64  `json:"channelId"`
+	AlertId    int64  `json:"alertId"`
+	Attempt    int64  `json:"attempt"`
+	Success    bool   `json:"success"`
+	StatusCode int64  `json:"statusCode"`
+	Error      string `json:"error"`
+	CreatedOn  string `json:"createdOn"`
+}
+
+const channelColumns = "id, name, type, target, secret, format, template, createdOn"
+
+// PayloadTemplateFuncs are available to custom webhook templates, json
+// writes a value as JSON so names with quotes keep the payload valid, e.g.
+// {"text": {{json .Message}}}
+var PayloadTemplateFuncs = template.FuncMap{
+	"json": func(value any) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+}
+
+func InitChannels() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS channels (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT,
+			type TEXT,
+			target TEXT,
+			secret TEXT,
+			format TEXT,
+			template TEXT,
+			createdOn TEXT
+		);`
+
+	_, err := db.Exec(query)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		CREATE TABLE IF NOT EXISTS deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			channelId INTEGER NOT NULL,
+			alertId INTEGER,
+			attempt INTEGER,
+			success INTEGER,
+			statusCode INTEGER,
+			error TEXT,
+			createdOn TEXT
+		);`
+
+	_, err = db.Exec(query)
+	return err
+}
+
+func scanChannel(row rowScanner) (Channel, error) {
+	var channel Channel
+	err := row.Scan(&channel.Id, &channel.Name, &channel.Type, &channel.Target, &channel.Secret,
+		&channel.Format, &channel.Template, &channel.CreatedOn)
+
+	return channel, err
+}
+
+func GetChannels() ([]Channel, error) {
+	var channels []Channel
+
+	rows, err := db.Query("select " + channelColumns + " from channels")
+	if err != nil {
+		return channels, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		channel, err := scanChannel(rows)
+		if err != nil {
+			return channels, err
+		}
+		channels = append(channels, channel)
+	}
+
+	return channels, nil
+}
+
+func GetChannel(channelId int64) (Channel, error) {
+	row := db.QueryRow("select "+channelColumns+" from channels where id = ?", channelId)
+
+	channel, err := scanChannel(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return channel, errors.New("Invalid channelId")
+		}
+	}
+
+	return channel, err
+}
+
+func validateChannel(channel Channel) error {
+	if channel.Name == "" {
+		return errors.New("Invalid name")
+	}
+
+	switch channel.Type {
+	case ChannelWebhook:
+		targetUrl, err := url.Parse(channel.Target)
+		if err != nil || (targetUrl.Scheme != "http" && targetUrl.Scheme != "https") || targetUrl.Host == "" {
+			return errors.New("Invalid target url")
+		}
+
+		switch channel.Format {
+		case FormatGeneric, FormatSlack:
+		case FormatCustom:
+			_, err := template.New("payload").Funcs(PayloadTemplateFuncs).Parse(channel.Template)
+			if err != nil {
+				return errors.New("Invalid template: " + err.Error())
+			}
+		default:
+			return errors.New("Invalid format value")
+		}
+
+	case ChannelEmail:
+		_, err := mail.ParseAddressList(channel.Target)
+		if err != nil {
+			return errors.New("Invalid target email addresses")
+		}
+
+	default:
+		return errors.New("Invalid channel type")
+	}
+
+	return nil
+}
+
+func CreateChannel(createChannel ChannelCreate) (Channel, error) {
+	channel := Channel{
+		Name:     createChannel.Name,
+		Type:     createChannel.Type,
+		Target:   createChannel.Target,
+		Secret:   createChannel.Secret,
+		Format:   createChannel.Format,
+		Template: createChannel.Template,
+	}
+
+	if channel.Format == "" {
+		channel.Format = FormatGeneric
+	}
+
+	err := validateChannel(channel)
+	if err != nil {
+		return channel, err
+	}
+
+	currentTime := time.Now()
+	formattedTime := currentTime.Format("2006-01-02 15:04:05")
+
+	result, err := db.Exec(
+		`
+		INSERT INTO channels (name, type, target, secret, format, template, createdOn)
+		values (?, ?, ?, ?, ?, ?, ?)
+		`,
+		channel.Name, channel.Type, channel.Target, channel.Secret, channel.Format, channel.Template, formattedTime)
+	if err != nil {
+		return channel, err
+	}
+
+	channelId, err := result.LastInsertId()
+	if err != nil {
+		return channel, err
+	}
+
+	return GetChannel(channelId)
+}
+
+func UpdateChannel(channelId int64, updateChannel ChannelUpdate) (Channel, error) {
+	channel, err := GetChannel(channelId)
+	if err != nil {
+		return channel, err
+	}
+
+	if updateChannel.Name != "" {
+		channel.Name = updateChannel.Name
+	}
+
+	if updateChannel.Target != "" {
+		channel.Target = updateChannel.Target
+	}
+
+	if updateChannel.Secret != "" {
+		channel.Secret = updateChannel.Secret
+	}
+
+	if updateChannel.Format != "" {
+		channel.Format = updateChannel.Format
+	}
+
+	if updateChannel.Template != "" {
+		channel.Template = updateChannel.Template
+	}
+
+	err = validateChannel(channel)
+	if err != nil {
+		return channel, err
+	}
+
+	_, err = db.Exec(`
+		UPDATE channels
+		set name = ?, target = ?, secret = ?, format = ?, template = ?
+		where id = ?`,
+		channel.Name, channel.Target, channel.Secret, channel.Format, channel.Template, channelId)
+	if err != nil {
+		return channel, err
+	}
+
+	return GetChannel(channelId)
+}
+
+func DeleteChannel(channelId int64) error {
+	_, err := GetChannel(channelId)
+	if err != nil {
+		return err
+	}
+
+	var reports int64
+	err = db.QueryRow("select count(*) from reports where channelId = ?", channelId).Scan(&reports)
+	if err != nil {
+		return err
+	}
+
+	if reports > 0 {
+		return errors.New("Channel is used by a report")
+	}
+
+	_, err = db.Exec("DELETE FROM deliveries where channelId = ?", channelId)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM channels where id = ?", channelId)
+	return err
+}
+
+func RecordDelivery(delivery Delivery) error {
+	currentTime := time.Now()
+	formattedTime := currentTime.Format("2006-01-02 15:04:05")
+
+	_, err := db.Exec(`
+		INSERT INTO deliveries (channelId, alertId, attempt, success, statusCode, error, createdOn)
+		values (?, ?, ?, ?, ?, ?, ?)`,
+		delivery.ChannelId, delivery.AlertId, delivery.Attempt, delivery.Success, delivery.StatusCode,
+		delivery.Error, formattedTime)
+
+	return err
+}
+
+func GetDeliveries(channelId int64) ([]Delivery, error) {
+	var deliveries []Delivery
+
+	_, err := GetChannel(channelId)
+	if err != nil {
+		return deliveries, err
+	}
+
+	rows, err := db.Query(`
+		select id, channelId, alertId, attempt, success, statusCode, error, createdOn
+		from deliveries where channelId = ? order by id desc limit 1000`, channelId)
+	if err != nil {
+		return deliveries, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var delivery Delivery
+		err := rows.Scan(&delivery.Id, &delivery.ChannelId, &delivery.AlertId, &delivery.Attempt, &delivery.Success,
+			&delivery.StatusCode, &delivery.Error, &delivery.CreatedOn)
+		if err != nil {
+			return deliveries, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	return deliveries, nil
+}
+
+// PruneDeliveries deletes the deliveries recorded before cutoff
+func PruneDeliveries(cutoff time.Time) error {
+	_, err := db.Exec("delete from deliveries where createdOn < ?", cutoff.Format("2006-01-02 15:04:05"))
+	return err
+}
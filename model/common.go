@@ -8,6 +8,9 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 var didInit bool = false
@@ -38,6 +41,27 @@ func tableExists(tableName string) (bool, error) {
 
 }
 
+func columnExists(tableName string, columnName string) (bool, error) {
+	query := `SELECT EXISTS (
+		SELECT 1
+		FROM pragma_table_info(?)
+		WHERE name = ?
+	);`
+
+	var exists bool
+	err := db.QueryRow(query, tableName, columnName).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("error checking column existence: %w", err)
+	}
+	return exists, nil
+}
+
+// DatabasePath returns the path of the database file, ~/.minim/data.db
+func DatabasePath() string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".minim", "data.db")
+}
+
 func InitCreateDb() {
 
 }
@@ -48,8 +72,7 @@ func Init() error {
 	}
 
 	var err error
-	homeDir, _ := os.UserHomeDir()
-	dbPath := filepath.Join(homeDir, ".minim", "data.db")
+	dbPath := DatabasePath()
 
 	exists, err := exists(dbPath)
 	if !exists {
@@ -71,15 +94,25 @@ func Init() error {
 		panic("Unable to connect to database")
 	}
 
-	tab, _ := tableExists("config")
-	if !tab {
-		err = InitConfig()
-		if err != nil {
-			return err
-		}
+	err = initTables()
+	if err != nil {
+		return err
 	}
 
-	tab, _ = tableExists("graphs")
+	didInit = true
+	return nil
+}
+
+// initTables creates the missing tables of the current database and
+// migrates the existing ones
+func initTables() error {
+	// Always run so config keys added in newer versions get their defaults
+	err := InitConfig()
+	if err != nil {
+		return err
+	}
+
+	tab, _ := tableExists("graphs")
 	if !tab {
 		err = InitGraphs()
 		if err != nil {
@@ -87,6 +120,11 @@ func Init() error {
 		}
 	}
 
+	err = MigrateGraphs()
+	if err != nil {
+		return err
+	}
+
 	tab, _ = tableExists("dashboards")
 	if !tab {
 		err = InitDashboards()
@@ -103,6 +141,92 @@ func Init() error {
 		}
 	}
 
-	didInit = true
+	err = MigrateEventDefs()
+	if err != nil {
+		return err
+	}
+
+	err = InitPendingEvents()
+	if err != nil {
+		return err
+	}
+
+	err = InitAlerts()
+	if err != nil {
+		return err
+	}
+
+	err = InitChannels()
+	if err != nil {
+		return err
+	}
+
+	err = InitDimensions()
+	if err != nil {
+		return err
+	}
+
+	err = InitSilences()
+	if err != nil {
+		return err
+	}
+
+	err = InitReports()
+	if err != nil {
+		return err
+	}
+
+	err = InitRelay()
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
+
+// useDatabase points the package at the database file at path, with its
+// tables created and migrated, until the returned function restores the
+// previous database. A single connection is used so attached databases
+// are visible to every query.
+func useDatabase(path string) (func(), error) {
+	previous := db
+
+	fileDb, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+	fileDb.SetMaxOpenConns(1)
+
+	restore := func() {
+		fileDb.Close()
+		db = previous
+	}
+
+	db = fileDb
+	err = initTables()
+	if err != nil {
+		restore()
+		return nil, err
+	}
+
+	return restore, nil
+}
+
+// ParseDuration extends time.ParseDuration with a day unit, e.g. "7d"
+func ParseDuration(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, errors.New("Invalid duration value")
+		}
+
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, errors.New("Invalid duration value")
+	}
+
+	return duration, nil
+}
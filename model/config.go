@@ -1,6 +1,7 @@
 package model
 
 import (
+	"sort"
 	"time"
 )
 
@@ -11,6 +12,58 @@ type Config struct {
 	CreatedOn string
 }
 
+var configDefaults = map[string]string{
+	"PORT":      "3333",
+	"UI_ENABLE": "1",
+
+	"CORS_INGEST_ORIGINS":     "*",
+	"CORS_INGEST_METHODS":     "GET, POST, OPTIONS",
+	"CORS_INGEST_CREDENTIALS": "0",
+	"CORS_ADMIN_ORIGINS":      "",
+	"CORS_ADMIN_METHODS":      "GET, POST, PATCH, DELETE, OPTIONS",
+	"CORS_ADMIN_CREDENTIALS":  "0",
+
+	"RATE_LIMIT_CLIENT":       "0",
+	"RATE_LIMIT_CLIENT_BURST": "0",
+	"RATE_LIMIT_EVENT":        "0",
+	"RATE_LIMIT_EVENT_BURST":  "0",
+	"MAX_EVENTS":              "0",
+	"MAX_DIMENSION_KEYS":      "20",
+	"MAX_EVENT_AGE":           "2d",
+	"MAX_DIMENSION_VALUES":    "100",
+
+	"EVENT_POLICY": "AUTO",
+	"INGEST_TOKEN": "",
+
+	"PIXEL_FILTER_BOTS": "1",
+	"PIXEL_RESPECT_DNT": "1",
+
+	"SMTP_HOST":     "",
+	"SMTP_PORT":     "587",
+	"SMTP_USERNAME": "",
+	"SMTP_PASSWORD": "",
+	"SMTP_FROM":     "",
+	"SMTP_STARTTLS": "1",
+
+	"STATSD_ENABLE": "0",
+	"STATSD_PORT":   "8125",
+	"STATSD_PREFIX": "",
+
+	"RELAY_URL":            "",
+	"RELAY_TOKEN":          "",
+	"RELAY_INTERVAL":       "60",
+	"RELAY_HOST":           "",
+	"RELAY_HOST_DIMENSION": "1",
+	"RELAY_ORIGINS":        "",
+
+	"BACKUP_SCHEDULE": "",
+	"BACKUP_API":      "0",
+	"BACKUP_DIR":      "",
+	"BACKUP_KEEP":     "7",
+
+	"DASHBOARD_PROVISION_DIR": "",
+}
+
 func InitConfig() error {
 	query := `
 		CREATE TABLE IF NOT EXISTS config (
@@ -27,21 +80,20 @@ func InitConfig() error {
 	currentTime := time.Now()
 	formattedTime := currentTime.Format("2006-01-02 15:04:05")
 
-	_, err = GetConfig("PORT")
-	if err != nil {
-		_, err = db.Exec("insert into config (key, value, createdOn) values (?, ?, ?)", "PORT", "3333", formattedTime)
-
-		if err != nil {
-			return err
-		}
+	keys := make([]string, 0, len(configDefaults))
+	for key := range configDefaults {
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
 
-	_, err = GetConfig("UI_ENABLE")
-	if err != nil {
-		_, err = db.Exec("insert into config (key, value, createdOn) values (?, ?, ?)", "UI_ENABLE", "1", formattedTime)
-
+	for _, key := range keys {
+		_, err = GetConfig(key)
 		if err != nil {
-			return err
+			_, err = db.Exec("insert into config (key, value, createdOn) values (?, ?, ?)", key, configDefaults[key], formattedTime)
+
+			if err != nil {
+				return err
+			}
 		}
 	}
 
@@ -57,6 +109,27 @@ func GetConfig(key string) (Config, error) {
 	return configItem, err
 }
 
+func GetConfigs() ([]Config, error) {
+	var configs []Config
+
+	rows, err := db.Query("select * from config order by key")
+	if err != nil {
+		return configs, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var configItem Config
+		err := rows.Scan(&configItem.Id, &configItem.Key, &configItem.Value, &configItem.CreatedOn)
+		if err != nil {
+			return configs, err
+		}
+		configs = append(configs, configItem)
+	}
+
+	return configs, nil
+}
+
 func GetConfigValue(key string) (string, error) {
 	configItem, err := GetConfig(key)
 	return configItem.Value, err
@@ -0,0 +1,138 @@
+package model
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression with the five standard fields:
+// minute, hour, day of month, month and day of week. Fields support *,
+// numbers, lists, ranges and steps, e.g. "0 9 * * 1" or "*/15 8-18 * * 1-5".
+// As in standard cron, when both the day of month and the day of week are
+// restricted a day matching either of them matches.
+type Schedule struct {
+	minutes  map[int]bool
+	hours    map[int]bool
+	days     map[int]bool
+	months   map[int]bool
+	weekdays map[int]bool
+	anyDay   bool
+}
+
+func parseCronField(field string, min int, max int) (map[int]bool, error) {
+	values := make(map[int]bool)
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if rangePart, stepPart, ok := strings.Cut(part, "/"); ok {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return nil, errors.New("Invalid step in schedule")
+			}
+			part = rangePart
+		}
+
+		start, end := min, max
+		if part != "*" {
+			startPart, endPart, isRange := strings.Cut(part, "-")
+
+			var err error
+			start, err = strconv.Atoi(startPart)
+			if err != nil {
+				return nil, errors.New("Invalid value in schedule")
+			}
+
+			end = start
+			if isRange {
+				end, err = strconv.Atoi(endPart)
+				if err != nil {
+					return nil, errors.New("Invalid range in schedule")
+				}
+			} else if step > 1 {
+				end = max
+			}
+		}
+
+		if start < min || end > max || start > end {
+			return nil, errors.New("Schedule value out of range")
+		}
+
+		for value := start; value <= end; value += step {
+			values[value] = true
+		}
+	}
+
+	return values, nil
+}
+
+func ParseSchedule(expression string) (Schedule, error) {
+	var schedule Schedule
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return schedule, errors.New("Schedule must have 5 fields")
+	}
+
+	var err error
+	schedule.minutes, err = parseCronField(fields[0], 0, 59)
+	if err != nil {
+		return schedule, err
+	}
+
+	schedule.hours, err = parseCronField(fields[1], 0, 23)
+	if err != nil {
+		return schedule, err
+	}
+
+	schedule.days, err = parseCronField(fields[2], 1, 31)
+	if err != nil {
+		return schedule, err
+	}
+
+	schedule.months, err = parseCronField(fields[3], 1, 12)
+	if err != nil {
+		return schedule, err
+	}
+
+	// Both 0 and 7 mean Sunday
+	schedule.weekdays, err = parseCronField(fields[4], 0, 7)
+	if err != nil {
+		return schedule, err
+	}
+	if schedule.weekdays[7] {
+		schedule.weekdays[0] = true
+	}
+
+	// A field starting with * is not a restriction, even with a step
+	schedule.anyDay = strings.HasPrefix(fields[2], "*") || strings.HasPrefix(fields[4], "*")
+
+	return schedule, nil
+}
+
+func (s Schedule) Matches(t time.Time) bool {
+	day := s.days[t.Day()] && s.weekdays[int(t.Weekday())]
+	if !s.anyDay {
+		day = s.days[t.Day()] || s.weekdays[int(t.Weekday())]
+	}
+
+	return s.minutes[t.Minute()] && s.hours[t.Hour()] && day && s.months[int(t.Month())]
+}
+
+// Next returns the first minute after t matching the schedule, a zero time
+// is returned when nothing matches within a year
+func (s Schedule) Next(t time.Time) time.Time {
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	limit := next.AddDate(1, 0, 0)
+
+	for next.Before(limit) {
+		if s.Matches(next) {
+			return next
+		}
+		next = next.Add(time.Minute)
+	}
+
+	return time.Time{}
+}
@@ -2,7 +2,11 @@ package model
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"time"
 )
 
@@ -27,6 +31,33 @@ type DashboardCreate struct {
 	Name string `json:"name"`
 }
 
+// DashboardClone names the copy of a dashboard, the name of the original
+// followed by (copy) when empty
+type DashboardClone struct {
+	Name string `json:"name"`
+}
+
+// DashboardDefinition is a portable dashboard without ids, its graphs refer
+// to their events by name so it can be kept in version control and imported
+// into any instance
+type DashboardDefinition struct {
+	Name   string            `json:"name"`
+	Graphs []GraphDefinition `json:"graphs"`
+}
+
+// GraphDefinition is a graph of a dashboard definition. Its layout is
+// optional, graphs without one are placed in the order they are listed.
+type GraphDefinition struct {
+	Name   string `json:"name"`
+	Event  string `json:"event"`
+	Period string `json:"period"`
+	Length int64  `json:"length"`
+	Row    int64  `json:"row,omitempty"`
+	Column int64  `json:"column,omitempty"`
+	Width  int64  `json:"width,omitempty"`
+	Height int64  `json:"height,omitempty"`
+}
+
 func InitDashboards() error {
 	query := `
 		CREATE TABLE IF NOT EXISTS dashboards (
@@ -177,6 +208,11 @@ func DeleteDashboard(dashboardId int64) error {
 		DeleteGraph(graphId)
 	}
 
+	_, err = db.Exec("DELETE FROM reports where dashboardId = ?", dashboardId)
+	if err != nil {
+		return err
+	}
+
 	_, err = db.Exec(
 		`
 		DELETE FROM dashboards where id = ?
@@ -200,3 +236,209 @@ func IsValidDashboard(dashboardId int64) (bool, error) {
 	}
 	return exists, nil
 }
+
+func ExportDashboard(dashboardId int64) (DashboardDefinition, error) {
+	definition := DashboardDefinition{Graphs: []GraphDefinition{}}
+
+	dashboard, err := GetDashboard(dashboardId)
+	if err != nil {
+		return definition, err
+	}
+
+	definition.Name = dashboard.Name
+	for _, graph := range dashboard.Graphs {
+		definition.Graphs = append(definition.Graphs, GraphDefinition{
+			Name:   graph.Name,
+			Event:  graph.Event,
+			Period: graph.Period,
+			Length: graph.Length,
+			Row:    graph.Row,
+			Column: graph.Column,
+			Width:  graph.Width,
+			Height: graph.Height,
+		})
+	}
+
+	return definition, nil
+}
+
+// ImportDashboard creates a dashboard from a definition. With replace, the
+// graphs of the dashboard with the same name are replaced instead. Every
+// graph is validated before anything is written. The layout of the
+// definition is applied when all of its graphs have one.
+func ImportDashboard(definition DashboardDefinition, replace bool) (DashboardGet, error) {
+	var dashboard DashboardGet
+
+	if definition.Name == "" {
+		return dashboard, errors.New("Invalid name for Dashboard")
+	}
+
+	for _, graph := range definition.Graphs {
+		err := validateGraph(graph.Name, graph.Event, graph.Period, graph.Length)
+		if err != nil {
+			return dashboard, fmt.Errorf("graph %s: %w", graph.Name, err)
+		}
+	}
+
+	layouts, err := definitionLayout(definition.Graphs)
+	if err != nil {
+		return dashboard, err
+	}
+
+	var dashboardId int64
+	if replace {
+		err := db.QueryRow("select id from dashboards where name = ? order by id limit 1", definition.Name).Scan(&dashboardId)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return dashboard, err
+		}
+	}
+
+	dashboardId, err = writeDashboard(dashboardId, definition.Name, definition.Graphs, layouts)
+	if err != nil {
+		return dashboard, err
+	}
+
+	return GetDashboard(dashboardId)
+}
+
+// writeDashboard creates the dashboard called name with the graphs of a
+// definition, or replaces the graphs of dashboardId when it is not zero, in
+// one transaction. The graphs are validated by the caller, and placed with
+// layouts when given or one under the other otherwise.
+func writeDashboard(dashboardId int64, name string, graphs []GraphDefinition, layouts []GraphLayout) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return dashboardId, err
+	}
+	defer tx.Rollback()
+
+	formattedTime := time.Now().Format("2006-01-02 15:04:05")
+
+	if dashboardId != 0 {
+		_, err = tx.Exec("DELETE FROM graphs where dashboardId = ?", dashboardId)
+		if err != nil {
+			return dashboardId, err
+		}
+	} else {
+		result, err := tx.Exec("INSERT INTO dashboards (name, createdOn) values (?, ?)", name, formattedTime)
+		if err != nil {
+			return dashboardId, err
+		}
+
+		dashboardId, err = result.LastInsertId()
+		if err != nil {
+			return dashboardId, err
+		}
+	}
+
+	for i, graph := range graphs {
+		if layouts != nil {
+			layout := layouts[i]
+			_, err = tx.Exec(`
+				INSERT INTO graphs (dashboardId, name, event, period, length, createdOn, position, gridRow, gridColumn, width, height)
+				values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				dashboardId, graph.Name, graph.Event, graph.Period, graph.Length, formattedTime,
+				layout.Position, layout.Row, layout.Column, layout.Width, layout.Height)
+			if err != nil {
+				return dashboardId, err
+			}
+			continue
+		}
+
+		position, row, err := nextGraphPlacement(tx, dashboardId)
+		if err != nil {
+			return dashboardId, err
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO graphs (dashboardId, name, event, period, length, createdOn, position, gridRow)
+			values (?, ?, ?, ?, ?, ?, ?, ?)`,
+			dashboardId, graph.Name, graph.Event, graph.Period, graph.Length, formattedTime, position, row)
+		if err != nil {
+			return dashboardId, err
+		}
+	}
+
+	return dashboardId, tx.Commit()
+}
+
+// ProvisionDashboards imports every .json dashboard definition in dir,
+// replacing the dashboards with the same names. A definition that fails to
+// import does not stop the others.
+func ProvisionDashboards(dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		var definition DashboardDefinition
+		err = json.Unmarshal(data, &definition)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", filepath.Base(path), err))
+			continue
+		}
+
+		_, err = ImportDashboard(definition, true)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", filepath.Base(path), err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// CloneDashboard creates a copy of a dashboard with copies of all its graphs
+// laid out the same way
+func CloneDashboard(dashboardId int64, clone DashboardClone) (DashboardGet, error) {
+	dashboard, err := GetDashboard(dashboardId)
+	if err != nil {
+		return dashboard, err
+	}
+
+	name := clone.Name
+	if name == "" {
+		name = dashboard.Name + " (copy)"
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return dashboard, err
+	}
+	defer tx.Rollback()
+
+	formattedTime := time.Now().Format("2006-01-02 15:04:05")
+
+	result, err := tx.Exec("INSERT INTO dashboards (name, createdOn) values (?, ?)", name, formattedTime)
+	if err != nil {
+		return dashboard, err
+	}
+
+	cloneId, err := result.LastInsertId()
+	if err != nil {
+		return dashboard, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO graphs (dashboardId, name, event, period, length, createdOn, position, gridRow, gridColumn, width, height)
+		SELECT ?, name, event, period, length, ?, position, gridRow, gridColumn, width, height
+		FROM graphs WHERE dashboardId = ? ORDER BY position, id`,
+		cloneId, formattedTime, dashboardId)
+	if err != nil {
+		return dashboard, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return dashboard, err
+	}
+
+	return GetDashboard(cloneId)
+}
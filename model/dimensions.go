@@ -0,0 +1,251 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Dimension is a key an event has been broken down by along with the values
+// seen for it, e.g. the attributes of OpenTelemetry data points
+type Dimension struct {
+	Key    string   `json:"key"`
+	Values []string `json:"values"`
+}
+
+// Dimension keys and values longer than these are dropped
+const maxDimensionKeyLength = 64
+const maxDimensionValueLength = 128
+
+func InitDimensions() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS dimensions (
+			event TEXT NOT NULL,
+			period TEXT NOT NULL,
+			time INTEGER NOT NULL,
+			key TEXT NOT NULL,
+			value TEXT NOT NULL,
+			count INTEGER NOT NULL DEFAULT 0,
+			samples INTEGER NOT NULL DEFAULT 0,
+			sum REAL NOT NULL DEFAULT 0,
+			min REAL,
+			max REAL,
+			PRIMARY KEY (event, period, time, key, value)
+		);`
+
+	_, err := db.Exec(query)
+	return err
+}
+
+// queryRower is implemented by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// isKnownDimensionValue reports whether a value can be recorded for the key,
+// keys beyond MAX_DIMENSION_KEYS per event and values beyond
+// MAX_DIMENSION_VALUES per event and key are dropped to keep the cardinality
+// bounded
+func isKnownDimensionValue(q queryRower, event string, key string, value string, maxKeys int, maxValues int) (bool, error) {
+	if maxKeys <= 0 && maxValues <= 0 {
+		return true, nil
+	}
+
+	var known bool
+	err := q.QueryRow("select exists (select 1 from dimensions where event = ? and key = ? and value = ?)",
+		event, key, value).Scan(&known)
+	if err != nil || known {
+		return known, err
+	}
+
+	if maxKeys > 0 {
+		var knownKey bool
+		err = q.QueryRow("select exists (select 1 from dimensions where event = ? and key = ?)", event, key).Scan(&knownKey)
+		if err != nil {
+			return false, err
+		}
+
+		if !knownKey {
+			var keys int
+			err = q.QueryRow("select count(distinct key) from dimensions where event = ? and period = 'daily'", event).Scan(&keys)
+			if err != nil || keys >= maxKeys {
+				return false, err
+			}
+		}
+	}
+
+	if maxValues <= 0 {
+		return true, nil
+	}
+
+	var count int
+	err = q.QueryRow("select count(distinct value) from dimensions where event = ? and key = ? and period = 'daily'",
+		event, key).Scan(&count)
+
+	return count < maxValues, err
+}
+
+// SubmitDimensionSample adds a sample of event to the buckets of each of its
+// dimensions
+func SubmitDimensionSample(event string, dimensions map[string]string, count int64, value *float64, t time.Time) error {
+	if len(dimensions) == 0 {
+		return nil
+	}
+
+	maxKeysConfig, _ := GetConfigValue("MAX_DIMENSION_KEYS")
+	maxKeys, _ := strconv.Atoi(maxKeysConfig)
+
+	maxValuesConfig, _ := GetConfigValue("MAX_DIMENSION_VALUES")
+	maxValues, _ := strconv.Atoi(maxValuesConfig)
+
+	var samples int64
+	var sum float64
+	if value != nil {
+		samples = 1
+		sum = *value
+	}
+
+	for key, dimensionValue := range dimensions {
+		if key == "" || len(key) > maxDimensionKeyLength || len(dimensionValue) > maxDimensionValueLength {
+			continue
+		}
+
+		ok, err := isKnownDimensionValue(db, event, key, dimensionValue, maxKeys, maxValues)
+		if err != nil {
+			CountDbError(err)
+			return err
+		}
+
+		if !ok {
+			continue
+		}
+
+		for _, period := range eventPeriods {
+			_, err = db.Exec(`
+				INSERT INTO dimensions (event, period, time, key, value, count, samples, sum, min, max)
+				values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT(event, period, time, key, value) DO UPDATE SET
+					count = count + excluded.count,
+					samples = samples + excluded.samples,
+					sum = sum + excluded.sum,
+					min = min(coalesce(min, excluded.min), coalesce(excluded.min, min)),
+					max = max(coalesce(max, excluded.max), coalesce(excluded.max, max))`,
+				event, period, bucketStart(period, t).Unix(), key, dimensionValue, count, samples, sum, value, value)
+			if err != nil {
+				CountDbError(err)
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func GetDimensions(event string) ([]Dimension, error) {
+	var dimensions []Dimension
+
+	rows, err := db.Query(`
+		select distinct key, value from dimensions
+		where event = ? and period = 'daily'
+		order by key, value`, event)
+	if err != nil {
+		return dimensions, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key, value string
+		err = rows.Scan(&key, &value)
+		if err != nil {
+			return dimensions, err
+		}
+
+		if len(dimensions) == 0 || dimensions[len(dimensions)-1].Key != key {
+			dimensions = append(dimensions, Dimension{Key: key})
+		}
+
+		last := &dimensions[len(dimensions)-1]
+		last.Values = append(last.Values, value)
+	}
+
+	return dimensions, nil
+}
+
+// GetDimensionValues returns the last length buckets of event for every
+// value of a dimension key, latest first
+func GetDimensionValues(event string, key string, period string, length int64) (map[string][]ValueStat, error) {
+	series := make(map[string][]ValueStat)
+
+	err := validateSeriesLength(period, length)
+	if err != nil {
+		return series, err
+	}
+
+	periodPrefix, ok := map[string]string{"DAILY": "daily", "HOURLY": "hourly", "MINUTELY": "minutely"}[period]
+	if !ok {
+		return series, errors.New("Invalid period")
+	}
+
+	startTime := bucketStart(periodPrefix, time.Now())
+	var buckets []int64
+	for i := 0; i < int(length); i++ {
+		switch periodPrefix {
+		case "daily":
+			buckets = append(buckets, startTime.AddDate(0, 0, -i).Unix())
+		case "hourly":
+			buckets = append(buckets, startTime.Add(-time.Duration(i)*time.Hour).Unix())
+		default:
+			buckets = append(buckets, startTime.Add(-time.Duration(i)*time.Minute).Unix())
+		}
+	}
+
+	rows, err := db.Query(`
+		select value, time, count, samples, sum, min, max from dimensions
+		where event = ? and key = ? and period = ? and time between ? and ?`,
+		event, key, periodPrefix, buckets[len(buckets)-1], buckets[0])
+	if err != nil {
+		return series, err
+	}
+	defer rows.Close()
+
+	found := make(map[string]map[int64]ValueStat)
+	for rows.Next() {
+		var value string
+		var valueStat ValueStat
+		err = rows.Scan(&value, &valueStat.Time, &valueStat.Count, &valueStat.Samples, &valueStat.Sum, &valueStat.Min, &valueStat.Max)
+		if err != nil {
+			return series, err
+		}
+
+		if valueStat.Samples > 0 {
+			avg := valueStat.Sum / float64(valueStat.Samples)
+			valueStat.Avg = &avg
+		}
+
+		if found[value] == nil {
+			found[value] = make(map[int64]ValueStat)
+		}
+		found[value][valueStat.Time] = valueStat
+	}
+
+	for value, valueStats := range found {
+		for _, bucket := range buckets {
+			valueStat, ok := valueStats[bucket]
+			if !ok {
+				valueStat = ValueStat{Time: bucket}
+			}
+			series[value] = append(series[value], valueStat)
+		}
+	}
+
+	return series, nil
+}
+
+// pruneDimensions applies the retention of the minutely and hourly event
+// tables to the dimensions
+func pruneDimensions(minutelyCutoff int64, hourlyCutoff int64) error {
+	_, err := db.Exec("delete from dimensions where (period = 'minutely' and time < ?) or (period = 'hourly' and time < ?)",
+		minutelyCutoff, hourlyCutoff)
+	return err
+}
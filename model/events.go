@@ -1,11 +1,18 @@
 package model
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 )
 
+var ErrEventLimit = errors.New("Event limit reached")
+
 type EventRow struct {
 	Time  int64
 	Count int64
@@ -17,9 +24,69 @@ type TimeStat struct {
 }
 
 type EventDef struct {
-	Id       string  `json:"id"`
-	Event    string  `json:"event"`
-	LastSeen *string `json:"lastSeen"`
+	Id          string   `json:"id"`
+	Event       string   `json:"event"`
+	LastSeen    *string  `json:"lastSeen"`
+	FirstSeen   *string  `json:"firstSeen"`
+	Total       int64    `json:"total"`
+	Description string   `json:"description"`
+	Unit        string   `json:"unit"`
+	Tags        []string `json:"tags"`
+	Owner       string   `json:"owner"`
+}
+
+// EventUpdate changes the fields that are present, an empty string clears
+// a field
+type EventUpdate struct {
+	Description *string  `json:"description"`
+	Unit        *string  `json:"unit"`
+	Tags        []string `json:"tags"`
+	Owner       *string  `json:"owner"`
+}
+
+type EventDefQuery struct {
+	Sort        string
+	Desc        bool
+	InactiveFor time.Duration
+	Tag         string
+}
+
+const eventDefColumns = "id, event, lastSeen, firstSeen, total, description, unit, tags, owner"
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanEventDef(row rowScanner) (EventDef, error) {
+	var eventDef EventDef
+	var tags string
+	err := row.Scan(&eventDef.Id, &eventDef.Event, &eventDef.LastSeen, &eventDef.FirstSeen, &eventDef.Total,
+		&eventDef.Description, &eventDef.Unit, &tags, &eventDef.Owner)
+
+	eventDef.Tags = splitTags(tags)
+	return eventDef, err
+}
+
+// Tags are stored as a comma separated list
+func splitTags(tags string) []string {
+	splitTags := []string{}
+	for _, tag := range strings.Split(tags, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			splitTags = append(splitTags, tag)
+		}
+	}
+
+	return splitTags
+}
+
+var eventDefSorts = map[string]string{
+	"":          "id",
+	"id":        "id",
+	"event":     "event",
+	"lastSeen":  "lastSeen",
+	"firstSeen": "firstSeen",
+	"total":     "total",
 }
 
 func InitEventDefs() error {
@@ -27,18 +94,146 @@ func InitEventDefs() error {
 		CREATE TABLE IF NOT EXISTS events (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			event TEXT,
-			lastSeen TEXT
+			lastSeen TEXT,
+			firstSeen TEXT,
+			total INTEGER NOT NULL DEFAULT 0,
+			description TEXT NOT NULL DEFAULT '',
+			unit TEXT NOT NULL DEFAULT '',
+			tags TEXT NOT NULL DEFAULT '',
+			owner TEXT NOT NULL DEFAULT ''
 		);`
 
 	_, err := db.Exec(query)
 	return err
 }
 
+// MigrateEventDefs adds the columns introduced after the events table was
+// first created
+func MigrateEventDefs() error {
+	err := migrateEventTotals()
+	if err != nil {
+		return err
+	}
+
+	err = migrateEventValues()
+	if err != nil {
+		return err
+	}
+
+	for _, column := range []string{"description", "unit", "tags", "owner"} {
+		exists, err := columnExists("events", column)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			query := fmt.Sprintf("ALTER TABLE events ADD COLUMN %s TEXT NOT NULL DEFAULT ''", column)
+			_, err = db.Exec(query)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return migrateEventUnique()
+}
+
+// migrateEventUnique makes event names unique so that concurrent first
+// sightings of an event cannot define it twice. Duplicates left by earlier
+// versions keep their oldest definition.
+func migrateEventUnique() error {
+	_, err := db.Exec("delete from events where id not in (select min(id) from events group by event)")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS events_event ON events (event)")
+	return err
+}
+
+// migrateEventTotals adds the activity columns. The all-time total is
+// rebuilt from the daily buckets which are never trimmed, first and last
+// seen are approximated from the buckets.
+func migrateEventTotals() error {
+	exists, err := columnExists("events", "total")
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = db.Exec("ALTER TABLE events ADD COLUMN firstSeen TEXT")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("ALTER TABLE events ADD COLUMN total INTEGER NOT NULL DEFAULT 0")
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.Query("select event from events")
+	if err != nil {
+		return err
+	}
+
+	var events []string
+	for rows.Next() {
+		var event string
+		err = rows.Scan(&event)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		events = append(events, event)
+	}
+	rows.Close()
+
+	for _, event := range events {
+		var total int64
+		var firstTime sql.NullInt64
+		query := fmt.Sprintf("select coalesce(sum(count), 0), min(time) from daily_%s", event)
+		err = db.QueryRow(query).Scan(&total, &firstTime)
+		if err != nil {
+			log.Println("Unable to backfill totals for", event, err)
+			continue
+		}
+
+		var lastTime sql.NullInt64
+		query = fmt.Sprintf("select coalesce((select max(time) from minutely_%s), (select max(time) from hourly_%s))", event, event)
+		err = db.QueryRow(query).Scan(&lastTime)
+		if err != nil {
+			log.Println("Unable to backfill last seen for", event, err)
+		}
+
+		var firstSeen *string
+		if firstTime.Valid {
+			formattedTime := time.Unix(firstTime.Int64, 0).Format("2006-01-02 15:04:05")
+			firstSeen = &formattedTime
+		}
+
+		var lastSeen *string
+		if lastTime.Valid {
+			formattedTime := time.Unix(lastTime.Int64, 0).Format("2006-01-02 15:04:05")
+			lastSeen = &formattedTime
+		}
+
+		_, err = db.Exec("update events set total = ?, firstSeen = ?, lastSeen = coalesce(lastSeen, ?) where event = ?", total, firstSeen, lastSeen, event)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func InitDailyEvent(event string) error {
 	query := fmt.Sprintf(`
 		CREATE TABLE IF NOT EXISTS daily_%s (
 			time INTEGER PRIMARY KEY,
-			count INTEGER
+			count INTEGER,
+			samples INTEGER NOT NULL DEFAULT 0,
+			sum REAL NOT NULL DEFAULT 0,
+			min REAL,
+			max REAL
 		);`, event)
 
 	_, err := db.Exec(query)
@@ -53,7 +248,11 @@ func InitHourlyEvent(event string) error {
 	query := fmt.Sprintf(`
 		CREATE TABLE IF NOT EXISTS hourly_%s (
 			time INTEGER PRIMARY KEY,
-			count INTEGER
+			count INTEGER,
+			samples INTEGER NOT NULL DEFAULT 0,
+			sum REAL NOT NULL DEFAULT 0,
+			min REAL,
+			max REAL
 		);`, event)
 
 	_, err := db.Exec(query)
@@ -68,7 +267,11 @@ func InitMinutelyEvent(event string) error {
 	query := fmt.Sprintf(`
 		CREATE TABLE IF NOT EXISTS minutely_%s (
 			time INTEGER PRIMARY KEY,
-			count INTEGER
+			count INTEGER,
+			samples INTEGER NOT NULL DEFAULT 0,
+			sum REAL NOT NULL DEFAULT 0,
+			min REAL,
+			max REAL
 		);`, event)
 
 	_, err := db.Exec(query)
@@ -80,17 +283,11 @@ func InitMinutelyEvent(event string) error {
 }
 
 func InitEvent(event string) error {
-	row := db.QueryRow("select * from events where event = ?", event)
-
-	var eventDef EventDef
-	err := row.Scan(&eventDef.Id, &eventDef.Event, &eventDef.LastSeen)
+	_, err := GetEventDef(event)
 	if err != nil {
 		log.Print(err)
 
-		db.Exec("insert into events (event) values (?)", event)
-		InitDailyEvent(event)
-		InitHourlyEvent(event)
-		InitMinutelyEvent(event)
+		_, err = CreateEventDef(event)
 	} else {
 		// Skip
 
@@ -99,24 +296,203 @@ func InitEvent(event string) error {
 	return err
 }
 
-func GetEventDefs() *[]EventDef {
-	rows, err := db.Query("select * from events")
+// Event names end up in table names so only a safe subset is accepted
+var eventNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,64}$`)
+
+func IsValidEventName(event string) bool {
+	return eventNamePattern.MatchString(event)
+}
+
+// MaxEvents returns the MAX_EVENTS limit on the number of events, zero
+// when unlimited
+func MaxEvents() int64 {
+	maxEventsConfig, _ := GetConfigValue("MAX_EVENTS")
+	maxEvents, _ := strconv.ParseInt(maxEventsConfig, 10, 64)
+	return max(maxEvents, 0)
+}
+
+// CreateEventDef defines an event and creates its tables. Defining an event
+// that already exists succeeds, so concurrent first sightings can all carry
+// on.
+func CreateEventDef(event string) (EventDef, error) {
+	return createEventDef(event, 0)
+}
+
+// CreateLimitedEventDef is CreateEventDef for events defined on behalf of
+// clients, first sightings and approvals, refused with ErrEventLimit once
+// MAX_EVENTS events exist
+func CreateLimitedEventDef(event string) (EventDef, error) {
+	return createEventDef(event, MaxEvents())
+}
+
+func createEventDef(event string, maxEvents int64) (EventDef, error) {
+	var eventDef EventDef
+
+	if !IsValidEventName(event) {
+		return eventDef, errors.New("Invalid event name")
+	}
+
+	// The limit is checked by the insert itself so concurrent sightings of
+	// new events cannot go past it
+	result, err := db.Exec(`
+		insert into events (event) select ?
+		where ? <= 0 or (select count(*) from events) < ?
+		on conflict(event) do nothing`,
+		event, maxEvents, maxEvents)
 	if err != nil {
-		panic(err)
+		return eventDef, err
+	}
+
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return eventDef, err
+	}
+
+	if inserted == 0 {
+		exists, err := IsValidEvent(event)
+		if err != nil {
+			return eventDef, err
+		}
+
+		if !exists {
+			return eventDef, ErrEventLimit
+		}
+	}
+
+	err = InitDailyEvent(event)
+	if err != nil {
+		return eventDef, err
+	}
+
+	err = InitHourlyEvent(event)
+	if err != nil {
+		return eventDef, err
+	}
+
+	err = InitMinutelyEvent(event)
+	if err != nil {
+		return eventDef, err
+	}
+
+	return GetEventDef(event)
+}
+
+func GetEventDef(event string) (EventDef, error) {
+	row := db.QueryRow("select "+eventDefColumns+" from events where event = ?", event)
+
+	eventDef, err := scanEventDef(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return eventDef, errors.New("Invalid event value")
+		}
 	}
 
+	return eventDef, err
+}
+
+func GetEventDefs(eventDefQuery EventDefQuery) ([]EventDef, error) {
 	var eventDefs []EventDef
+
+	sortColumn, ok := eventDefSorts[eventDefQuery.Sort]
+	if !ok {
+		return eventDefs, errors.New("Invalid sort value")
+	}
+
+	order := "asc"
+	if eventDefQuery.Desc {
+		order = "desc"
+	}
+
+	var conditions []string
+	var args []any
+
+	// Events that were never seen count as inactive
+	if eventDefQuery.InactiveFor > 0 {
+		cutoff := time.Now().Add(-eventDefQuery.InactiveFor).Format("2006-01-02 15:04:05")
+		conditions = append(conditions, "(lastSeen is null or lastSeen < ?)")
+		args = append(args, cutoff)
+	}
+
+	if eventDefQuery.Tag != "" {
+		conditions = append(conditions, "instr(',' || tags || ',', ?) > 0")
+		args = append(args, ","+eventDefQuery.Tag+",")
+	}
+
+	query := "select " + eventDefColumns + " from events"
+	if len(conditions) > 0 {
+		query += " where " + strings.Join(conditions, " and ")
+	}
+
+	query += fmt.Sprintf(" order by %s %s, id", sortColumn, order)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return eventDefs, err
+	}
+	defer rows.Close()
+
 	for rows.Next() {
-		var eventDef EventDef
-		err := rows.Scan(&eventDef.Id, &eventDef.Event, &eventDef.LastSeen)
+		eventDef, err := scanEventDef(rows)
 		if err != nil {
-			// Handle scan error
-			panic(err)
+			return eventDefs, err
 		}
 		eventDefs = append(eventDefs, eventDef)
 	}
 
-	return &eventDefs
+	return eventDefs, nil
+}
+
+func UpdateEventDef(event string, updateEvent EventUpdate) (EventDef, error) {
+	eventDef, err := GetEventDef(event)
+	if err != nil {
+		return eventDef, err
+	}
+
+	tags := strings.Join(eventDef.Tags, ",")
+	if updateEvent.Tags != nil {
+		var cleanTags []string
+		for _, tag := range updateEvent.Tags {
+			tag = strings.TrimSpace(tag)
+			if strings.Contains(tag, ",") {
+				return eventDef, errors.New("Invalid tag value")
+			}
+
+			if tag != "" {
+				cleanTags = append(cleanTags, tag)
+			}
+		}
+
+		tags = strings.Join(cleanTags, ",")
+	}
+
+	description := eventDef.Description
+	if updateEvent.Description != nil {
+		description = *updateEvent.Description
+	}
+
+	unit := eventDef.Unit
+	if updateEvent.Unit != nil {
+		unit = *updateEvent.Unit
+	}
+
+	owner := eventDef.Owner
+	if updateEvent.Owner != nil {
+		owner = *updateEvent.Owner
+	}
+
+	_, err = db.Exec(`
+		UPDATE events
+		set description = ?,
+			unit = ?,
+			tags = ?,
+			owner = ?
+		where event = ?`,
+		description, unit, tags, owner, event)
+	if err != nil {
+		return eventDef, err
+	}
+
+	return GetEventDef(event)
 }
 
 func IsValidEvent(event string) (bool, error) {
@@ -135,7 +511,7 @@ func IsValidEvent(event string) (bool, error) {
 }
 
 func DeleteEvents() {
-	rows, err := db.Query("select * from events")
+	rows, err := db.Query("select event from events")
 	if err != nil {
 		panic(err)
 	}
@@ -143,13 +519,13 @@ func DeleteEvents() {
 
 	var events []string
 	for rows.Next() {
-		var eventDef EventDef
-		err = rows.Scan(&eventDef.Id, &eventDef.Event, &eventDef.LastSeen)
+		var event string
+		err = rows.Scan(&event)
 		if err != nil {
 			panic(err)
 		}
 
-		events = append(events, eventDef.Event)
+		events = append(events, event)
 	}
 
 	for _, event := range events {
@@ -170,6 +546,16 @@ func DeleteEvents() {
 
 	}
 
+	err = pruneDimensions(time.Now().Unix()-3600, time.Now().Unix()-3600*60)
+	if err != nil {
+		log.Println(err)
+	}
+
+	err = pruneRelayBuckets(time.Now().Unix()-3600, time.Now().Unix()-3600*60)
+	if err != nil {
+		log.Println(err)
+	}
+
 }
 
 func SubmitDailyEvent(event string) {
@@ -178,19 +564,21 @@ func SubmitDailyEvent(event string) {
 	dayStart := time.Date(currentTime.Year(), currentTime.Month(), currentTime.Day(), 0, 0, 0, 0, currentTime.Location())
 	time := dayStart.Unix()
 
-	query := fmt.Sprintf("select * from daily_%s where time = ?", event)
+	query := fmt.Sprintf("select time, count from daily_%s where time = ?", event)
 
 	row := db.QueryRow(query, time)
 	var eventRow EventRow
 	err := row.Scan(&eventRow.Time, &eventRow.Count)
 	if err != nil {
 		query = fmt.Sprintf("insert into daily_%s (time, count) values (?, ?)", event)
-		db.Exec(query, time, 1)
+		_, err = db.Exec(query, time, 1)
+		CountDbError(err)
 
 	} else {
 		nextCount := eventRow.Count + 1
 		query = fmt.Sprintf("update daily_%s set count = ? where time = ?", event)
-		db.Exec(query, nextCount, time)
+		_, err = db.Exec(query, nextCount, time)
+		CountDbError(err)
 	}
 }
 
@@ -200,19 +588,21 @@ func SubmitHourlyEvent(event string) {
 	hourStart := currentTime.Truncate(time.Hour)
 	time := hourStart.Unix()
 
-	query := fmt.Sprintf("select * from hourly_%s where time = ?", event)
+	query := fmt.Sprintf("select time, count from hourly_%s where time = ?", event)
 
 	row := db.QueryRow(query, time)
 	var eventRow EventRow
 	err := row.Scan(&eventRow.Time, &eventRow.Count)
 	if err != nil {
 		query = fmt.Sprintf("insert into hourly_%s (time, count) values (?, ?)", event)
-		db.Exec(query, time, 1)
+		_, err = db.Exec(query, time, 1)
+		CountDbError(err)
 
 	} else {
 		nextCount := eventRow.Count + 1
 		query = fmt.Sprintf("update hourly_%s set count = ? where time = ?", event)
-		db.Exec(query, nextCount, time)
+		_, err = db.Exec(query, nextCount, time)
+		CountDbError(err)
 
 	}
 }
@@ -223,19 +613,21 @@ func SubmitMinuteEvent(event string) {
 	minuteStart := currentTime.Truncate(time.Minute)
 	time := minuteStart.Unix()
 
-	query := fmt.Sprintf("select * from minutely_%s where time = ?", event)
+	query := fmt.Sprintf("select time, count from minutely_%s where time = ?", event)
 
 	row := db.QueryRow(query, time)
 	var eventRow EventRow
 	err := row.Scan(&eventRow.Time, &eventRow.Count)
 	if err != nil {
 		query = fmt.Sprintf("insert into minutely_%s (time, count) values (?, ?)", event)
-		db.Exec(query, time, 1)
+		_, err = db.Exec(query, time, 1)
+		CountDbError(err)
 
 	} else {
 		nextCount := eventRow.Count + 1
 		query = fmt.Sprintf("update minutely_%s set count = ? where time = ?", event)
-		db.Exec(query, nextCount, time)
+		_, err = db.Exec(query, nextCount, time)
+		CountDbError(err)
 
 	}
 }
@@ -249,7 +641,7 @@ func GetDailyStat(event string) *[60]TimeStat {
 	fromTime := dayStart.AddDate(0, 0, -60)
 	fromTimestamp := time.Date(fromTime.Year(), fromTime.Month(), fromTime.Day(), 0, 0, 0, 0, fromTime.Location()).Unix()
 
-	query := fmt.Sprintf("select * from daily_%s where time between ? and ?", event)
+	query := fmt.Sprintf("select time, count from daily_%s where time between ? and ?", event)
 
 	rows, err := db.Query(query, fromTimestamp, toTimestamp)
 	if err != nil {
@@ -299,7 +691,7 @@ func GetHourlyStat(event string) *[60]TimeStat {
 	fromTime := hourStart.Add(-60 * time.Hour)
 	fromTimestamp := fromTime.Unix()
 
-	query := fmt.Sprintf("select * from hourly_%s where time between ? and ?", event)
+	query := fmt.Sprintf("select time, count from hourly_%s where time between ? and ?", event)
 
 	rows, err := db.Query(query, fromTimestamp, toTimestamp)
 	if err != nil {
@@ -348,7 +740,7 @@ func GetMinuteStat(event string) *[60]TimeStat {
 	fromTime := minuteStart.Add(-60 * time.Minute)
 	fromTimestamp := fromTime.Unix()
 
-	query := fmt.Sprintf("select * from minutely_%s where time between ? and ?", event)
+	query := fmt.Sprintf("select time, count from minutely_%s where time between ? and ?", event)
 
 	rows, err := db.Query(query, fromTimestamp, toTimestamp)
 	if err != nil {
@@ -419,7 +811,7 @@ func GetEventData(event string, period string, length int64) ([]TimeStat, error)
 	toTimestamp = startTime.Unix()
 	fromTimestamp := time.Date(fromTime.Year(), fromTime.Month(), fromTime.Day(), 0, 0, 0, 0, fromTime.Location()).Unix()
 
-	query := fmt.Sprintf("select * from %s_%s where time between ? and ?", periodPrefix, event)
+	query := fmt.Sprintf("select time, count from %s_%s where time between ? and ?", periodPrefix, event)
 	rows, err := db.Query(query, fromTimestamp, toTimestamp)
 	if err != nil {
 		return statsArray, err
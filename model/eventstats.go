@@ -0,0 +1,107 @@
+package model
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+type eventSeen struct {
+	count int64
+	first time.Time
+	last  time.Time
+}
+
+// Ingestion only records sightings in memory, they are written to the
+// events table in one transaction by FlushEventStats
+var seenMu sync.Mutex
+var seenEvents = make(map[string]*eventSeen)
+
+func RecordEventSeen(event string, count int64, seenTime time.Time) {
+	seenMu.Lock()
+	defer seenMu.Unlock()
+
+	seen, ok := seenEvents[event]
+	if !ok {
+		seenEvents[event] = &eventSeen{count: count, first: seenTime, last: seenTime}
+		return
+	}
+
+	seen.count += count
+	if seenTime.Before(seen.first) {
+		seen.first = seenTime
+	}
+	if seenTime.After(seen.last) {
+		seen.last = seenTime
+	}
+}
+
+func FlushEventStats() error {
+	seenMu.Lock()
+	pending := seenEvents
+	seenEvents = make(map[string]*eventSeen)
+	seenMu.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		CountDbError(err)
+		restoreEventStats(pending)
+		return err
+	}
+	defer tx.Rollback()
+
+	for event, seen := range pending {
+		first := seen.first.Format("2006-01-02 15:04:05")
+		last := seen.last.Format("2006-01-02 15:04:05")
+
+		_, err = tx.Exec(`
+			UPDATE events
+			set total = total + ?,
+				firstSeen = min(coalesce(firstSeen, ?), ?),
+				lastSeen = max(coalesce(lastSeen, ?), ?)
+			where event = ?`,
+			seen.count, first, first, last, last, event)
+		if err != nil {
+			CountDbError(err)
+			restoreEventStats(pending)
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		CountDbError(err)
+		restoreEventStats(pending)
+	}
+
+	return err
+}
+
+// restoreEventStats puts back sightings of a failed flush so they are
+// retried with the next one
+func restoreEventStats(pending map[string]*eventSeen) {
+	log.Println("Unable to flush event stats, retrying later")
+
+	seenMu.Lock()
+	defer seenMu.Unlock()
+
+	for event, seen := range pending {
+		current, ok := seenEvents[event]
+		if !ok {
+			seenEvents[event] = seen
+			continue
+		}
+
+		current.count += seen.count
+		if seen.first.Before(current.first) {
+			current.first = seen.first
+		}
+		if seen.last.After(current.last) {
+			current.last = seen.last
+		}
+	}
+}
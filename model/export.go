@@ -0,0 +1,500 @@
+package model
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version of the export format, increased when it changes incompatibly
+const exportVersion = 1
+
+// Config keys left out of exports unless secrets are requested
+var secretConfigKeys = []string{"SMTP_PASSWORD", "INGEST_TOKEN", "RELAY_TOKEN", "RELAY_ORIGINS"}
+
+func IsSecretConfigKey(key string) bool {
+	return slices.Contains(secretConfigKeys, key)
+}
+
+// Export is a portable dump of the events with their buckets at every
+// resolution, the dashboards with their graphs and the config
+type Export struct {
+	Version    int               `json:"version"`
+	ExportedOn string            `json:"exportedOn"`
+	Events     []ExportEvent     `json:"events"`
+	Dashboards []DashboardGet    `json:"dashboards"`
+	Config     map[string]string `json:"config"`
+}
+
+// ExportEvent is an event definition with its buckets by period, daily,
+// hourly and minutely
+type ExportEvent struct {
+	EventDef
+	Buckets map[string][]ValueStat `json:"buckets"`
+}
+
+// ExportQuery limits an export to some events and to the buckets between
+// From and To, unix times where zero is unbounded
+type ExportQuery struct {
+	Events  []string
+	From    int64
+	To      int64
+	Secrets bool
+}
+
+// Conflict policies of an import for the data already in the instance
+const (
+	ImportSkip      = "skip"
+	ImportOverwrite = "overwrite"
+	ImportSum       = "sum"
+)
+
+// ImportQuery sets the conflict policy of an import and whether the config
+// of the export is applied, which is only done from the command line
+type ImportQuery struct {
+	Conflict string
+	Config   bool
+}
+
+type ImportResult struct {
+	Events     int      `json:"events"`
+	NewEvents  int      `json:"newEvents"`
+	Buckets    int      `json:"buckets"`
+	Dashboards int      `json:"dashboards"`
+	Graphs     int      `json:"graphs"`
+	Config     int      `json:"config"`
+	Errors     []string `json:"errors"`
+}
+
+// ParseExportTime reads a unix time or a YYYY-MM-DD date, end selects the
+// last second of the date instead of the first. An empty value is zero.
+func ParseExportTime(value string, end bool) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return unix, nil
+	}
+
+	date, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return 0, errors.New("Invalid time, expected a unix time or YYYY-MM-DD")
+	}
+
+	if end {
+		return date.AddDate(0, 0, 1).Unix() - 1, nil
+	}
+
+	return date.Unix(), nil
+}
+
+// GetExportBuckets returns the buckets of event between from and to for
+// every period, oldest first
+func GetExportBuckets(event string, from int64, to int64) (map[string][]ValueStat, error) {
+	buckets := make(map[string][]ValueStat)
+
+	if to == 0 {
+		to = time.Now().Unix()
+	}
+
+	for _, period := range eventPeriods {
+		query := fmt.Sprintf("select time, count, samples, sum, min, max from %s_%s where time between ? and ? order by time", period, event)
+		rows, err := db.Query(query, from, to)
+		if err != nil {
+			return buckets, err
+		}
+
+		buckets[period] = []ValueStat{}
+		for rows.Next() {
+			var valueStat ValueStat
+			err = rows.Scan(&valueStat.Time, &valueStat.Count, &valueStat.Samples, &valueStat.Sum, &valueStat.Min, &valueStat.Max)
+			if err != nil {
+				rows.Close()
+				return buckets, err
+			}
+
+			if valueStat.Samples > 0 {
+				avg := valueStat.Sum / float64(valueStat.Samples)
+				valueStat.Avg = &avg
+			}
+
+			buckets[period] = append(buckets[period], valueStat)
+		}
+		rows.Close()
+	}
+
+	return buckets, nil
+}
+
+// ExportEvents returns the events of the query with their buckets
+func ExportEvents(query ExportQuery) ([]ExportEvent, error) {
+	var exportEvents []ExportEvent
+
+	eventDefs, err := GetEventDefs(EventDefQuery{})
+	if err != nil {
+		return exportEvents, err
+	}
+
+	for _, event := range query.Events {
+		exists, err := IsValidEvent(event)
+		if err != nil {
+			return exportEvents, err
+		}
+		if !exists {
+			return exportEvents, fmt.Errorf("Invalid event value: %s", event)
+		}
+	}
+
+	for _, eventDef := range eventDefs {
+		if len(query.Events) > 0 && !slices.Contains(query.Events, eventDef.Event) {
+			continue
+		}
+
+		buckets, err := GetExportBuckets(eventDef.Event, query.From, query.To)
+		if err != nil {
+			return exportEvents, err
+		}
+
+		exportEvents = append(exportEvents, ExportEvent{EventDef: eventDef, Buckets: buckets})
+	}
+
+	return exportEvents, nil
+}
+
+func ExportData(query ExportQuery) (Export, error) {
+	export := Export{
+		Version:    exportVersion,
+		ExportedOn: time.Now().Format("2006-01-02 15:04:05"),
+		Config:     make(map[string]string),
+	}
+
+	var err error
+	export.Events, err = ExportEvents(query)
+	if err != nil {
+		return export, err
+	}
+
+	dashboards, err := GetDashboards()
+	if err != nil {
+		return export, err
+	}
+
+	exported := make(map[string]bool)
+	for _, exportEvent := range export.Events {
+		exported[exportEvent.EventDef.Event] = true
+	}
+
+	for _, dashboard := range dashboards {
+		dashboardGet, err := GetDashboard(dashboard.Id)
+		if err != nil {
+			return export, err
+		}
+
+		// An export of some events only keeps the graphs of those events,
+		// and the dashboards that still have graphs, so it imports cleanly
+		if len(query.Events) > 0 {
+			graphs := dashboardGet.Graphs
+			dashboardGet.Graphs = nil
+			for _, graph := range graphs {
+				if exported[graph.Event] {
+					dashboardGet.Graphs = append(dashboardGet.Graphs, graph)
+				}
+			}
+
+			if len(dashboardGet.Graphs) == 0 {
+				continue
+			}
+		}
+
+		export.Dashboards = append(export.Dashboards, dashboardGet)
+	}
+
+	configs, err := GetConfigs()
+	if err != nil {
+		return export, err
+	}
+
+	for _, config := range configs {
+		if !query.Secrets && IsSecretConfigKey(config.Key) {
+			continue
+		}
+		export.Config[config.Key] = config.Value
+	}
+
+	return export, nil
+}
+
+// WriteExportCsv writes the buckets of an event as CSV with one row per
+// bucket
+func WriteExportCsv(w io.Writer, exportEvent ExportEvent) error {
+	writer := csv.NewWriter(w)
+
+	err := writer.Write([]string{"period", "time", "date", "count", "samples", "sum", "min", "max", "avg"})
+	if err != nil {
+		return err
+	}
+
+	formatFloat := func(value *float64) string {
+		if value == nil {
+			return ""
+		}
+		return strconv.FormatFloat(*value, 'f', -1, 64)
+	}
+
+	for _, period := range eventPeriods {
+		for _, bucket := range exportEvent.Buckets[period] {
+			err = writer.Write([]string{
+				period,
+				strconv.FormatInt(bucket.Time, 10),
+				time.Unix(bucket.Time, 0).Format("2006-01-02 15:04:05"),
+				strconv.FormatInt(bucket.Count, 10),
+				strconv.FormatInt(bucket.Samples, 10),
+				strconv.FormatFloat(bucket.Sum, 'f', -1, 64),
+				formatFloat(bucket.Min),
+				formatFloat(bucket.Max),
+				formatFloat(bucket.Avg),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// importBuckets writes the buckets of an event, existing buckets are kept,
+// replaced or added to depending on conflict
+func importBuckets(event string, buckets map[string][]ValueStat, conflict string) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	onConflict := map[string]string{
+		ImportSkip: "DO NOTHING",
+		ImportOverwrite: `DO UPDATE SET
+			count = excluded.count,
+			samples = excluded.samples,
+			sum = excluded.sum,
+			min = excluded.min,
+			max = excluded.max`,
+		ImportSum: `DO UPDATE SET
+			count = count + excluded.count,
+			samples = samples + excluded.samples,
+			sum = sum + excluded.sum,
+			min = min(coalesce(min, excluded.min), coalesce(excluded.min, min)),
+			max = max(coalesce(max, excluded.max), coalesce(excluded.max, max))`,
+	}[conflict]
+
+	count := 0
+	for period, periodBuckets := range buckets {
+		if !slices.Contains(eventPeriods, period) {
+			return 0, fmt.Errorf("Invalid period %s", period)
+		}
+
+		query := fmt.Sprintf(`
+			INSERT INTO %s_%s (time, count, samples, sum, min, max) values (?, ?, ?, ?, ?, ?)
+			ON CONFLICT(time) %s`,
+			period, event, onConflict)
+
+		for _, bucket := range periodBuckets {
+			_, err = tx.Exec(query, bucket.Time, bucket.Count, bucket.Samples, bucket.Sum, bucket.Min, bucket.Max)
+			if err != nil {
+				return 0, err
+			}
+			count++
+		}
+	}
+
+	return count, tx.Commit()
+}
+
+// importEvent creates or updates an event from an export. The all-time
+// total is rebuilt from the daily buckets once they are imported.
+func importEvent(exportEvent ExportEvent, conflict string, result *ImportResult) error {
+	event := exportEvent.Event
+
+	exists, err := IsValidEvent(event)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		_, err = CreateEventDef(event)
+		if err != nil {
+			return err
+		}
+		result.NewEvents++
+	}
+
+	tags := strings.Join(exportEvent.Tags, ",")
+	if !exists || conflict == ImportOverwrite {
+		_, err = db.Exec("update events set description = ?, unit = ?, tags = ?, owner = ? where event = ?",
+			exportEvent.Description, exportEvent.Unit, tags, exportEvent.Owner, event)
+	} else {
+		_, err = db.Exec(`
+			UPDATE events
+			set description = coalesce(nullif(description, ''), ?),
+				unit = coalesce(nullif(unit, ''), ?),
+				tags = coalesce(nullif(tags, ''), ?),
+				owner = coalesce(nullif(owner, ''), ?)
+			where event = ?`,
+			exportEvent.Description, exportEvent.Unit, tags, exportEvent.Owner, event)
+	}
+	if err != nil {
+		return err
+	}
+
+	buckets, err := importBuckets(event, exportEvent.Buckets, conflict)
+	if err != nil {
+		return err
+	}
+	result.Buckets += buckets
+
+	_, err = db.Exec(fmt.Sprintf(`
+		UPDATE events
+		set total = (select coalesce(sum(count), 0) from daily_%s),
+			firstSeen = min(coalesce(firstSeen, ?), coalesce(?, firstSeen)),
+			lastSeen = max(coalesce(lastSeen, ?), coalesce(?, lastSeen))
+		where event = ?`, event),
+		exportEvent.FirstSeen, exportEvent.FirstSeen, exportEvent.LastSeen, exportEvent.LastSeen, event)
+	if err != nil {
+		return err
+	}
+
+	result.Events++
+	return nil
+}
+
+// importDashboard creates a dashboard from an export. A dashboard with the
+// same name is left alone, unless conflict is overwrite in which case its
+// graphs are replaced. Graphs and layouts are checked before anything is
+// written, invalid graphs are left out and an invalid layout falls back to
+// the default placement.
+func importDashboard(dashboard DashboardGet, existing map[string]int64, conflict string, result *ImportResult) error {
+	dashboardId, exists := existing[dashboard.Name]
+	if exists && conflict != ImportOverwrite {
+		return nil
+	}
+
+	var graphs []GraphDefinition
+	for _, graph := range dashboard.Graphs {
+		err := validateGraph(graph.Name, graph.Event, graph.Period, graph.Length)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("graph %s of dashboard %s: %v", graph.Name, dashboard.Name, err))
+			continue
+		}
+
+		graphs = append(graphs, GraphDefinition{graph.Name, graph.Event, graph.Period, graph.Length, graph.Row, graph.Column, graph.Width, graph.Height})
+	}
+
+	// Exports taken before layouts existed keep the default placement, as
+	// do dashboards missing some of their graphs
+	var layouts []GraphLayout
+	if len(graphs) == len(dashboard.Graphs) {
+		var err error
+		layouts, err = definitionLayout(graphs)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("layout of dashboard %s: %v", dashboard.Name, err))
+		}
+	}
+
+	dashboardId, err := writeDashboard(dashboardId, dashboard.Name, graphs, layouts)
+	if err != nil {
+		return err
+	}
+
+	existing[dashboard.Name] = dashboardId
+	result.Dashboards++
+	result.Graphs += len(graphs)
+
+	return nil
+}
+
+// ImportData restores an export. The conflict of query decides what happens
+// to data that already exists: skip keeps it, overwrite replaces it and sum
+// adds the imported counts to it. Config keys are ignored unless query.Config
+// is set, and are then only imported over their default value unless
+// conflict is overwrite. Graphs that cannot be created are reported in the
+// errors of the result.
+func ImportData(export Export, query ImportQuery) (ImportResult, error) {
+	var result ImportResult
+
+	conflict := query.Conflict
+	if conflict == "" {
+		conflict = ImportSkip
+	}
+
+	if conflict != ImportSkip && conflict != ImportOverwrite && conflict != ImportSum {
+		return result, errors.New("Invalid conflict value")
+	}
+
+	if export.Version != exportVersion {
+		return result, fmt.Errorf("Unsupported export version %d", export.Version)
+	}
+
+	for _, exportEvent := range export.Events {
+		if !IsValidEventName(exportEvent.Event) {
+			return result, fmt.Errorf("Invalid event name %s", exportEvent.Event)
+		}
+
+		err := importEvent(exportEvent, conflict, &result)
+		if err != nil {
+			return result, fmt.Errorf("%s: %w", exportEvent.Event, err)
+		}
+	}
+
+	dashboards, err := GetDashboards()
+	if err != nil {
+		return result, err
+	}
+
+	existing := make(map[string]int64)
+	for _, dashboard := range dashboards {
+		existing[dashboard.Name] = dashboard.Id
+	}
+
+	for _, dashboard := range export.Dashboards {
+		err = importDashboard(dashboard, existing, conflict, &result)
+		if err != nil {
+			return result, fmt.Errorf("dashboard %s: %w", dashboard.Name, err)
+		}
+	}
+
+	if !query.Config {
+		return result, nil
+	}
+
+	for key, value := range export.Config {
+		defaultValue, known := configDefaults[key]
+		if !known {
+			continue
+		}
+
+		current, err := GetConfigValue(key)
+		if err != nil {
+			return result, err
+		}
+
+		if current == value || conflict != ImportOverwrite && current != defaultValue {
+			continue
+		}
+
+		err = SetConfig(key, value)
+		if err != nil {
+			return result, err
+		}
+		result.Config++
+	}
+
+	return result, nil
+}
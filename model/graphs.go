@@ -3,6 +3,8 @@ package model
 import (
 	"database/sql"
 	"errors"
+	"fmt"
+	"slices"
 	"time"
 	// "minimalytics/model"
 )
@@ -15,6 +17,38 @@ type Graph struct {
 	Period      string `json:"period"`
 	Length      int64  `json:"length"`
 	CreatedOn   string `json:"createdOn"`
+	Broken      bool   `json:"broken"`
+	Unit        string `json:"unit"`
+	Position    int64  `json:"position"`
+	Row         int64  `json:"row"`
+	Column      int64  `json:"column"`
+	Width       int64  `json:"width"`
+	Height      int64  `json:"height"`
+}
+
+// Dashboards are laid out on a grid dashboardColumns wide. Graphs are
+// defaultGraphWidth columns wide and defaultGraphHeight rows high unless
+// resized.
+const dashboardColumns = 12
+const defaultGraphWidth = 6
+const defaultGraphHeight = 1
+const maxGraphHeight = 12
+
+const graphColumns = "id, dashboardId, name, event, period, length, createdOn, position, gridRow, gridColumn, width, height"
+
+// GraphLayout places a graph on its dashboard. Graphs are listed by position,
+// row and column are counted from 0 at the top left of the grid.
+type GraphLayout struct {
+	Id       int64 `json:"id"`
+	Position int64 `json:"position"`
+	Row      int64 `json:"row"`
+	Column   int64 `json:"column"`
+	Width    int64 `json:"width"`
+	Height   int64 `json:"height"`
+}
+
+type DashboardLayout struct {
+	Graphs []GraphLayout `json:"graphs"`
 }
 
 type GraphUpdate struct {
@@ -24,6 +58,11 @@ type GraphUpdate struct {
 	Length int64  `json:"length"`
 }
 
+// GraphTarget is the dashboard a graph is copied or moved to
+type GraphTarget struct {
+	DashboardId int64 `json:"dashboardId"`
+}
+
 type GraphCreate struct {
 	DashboardId int64  `json:"dashboardId"`
 	Name        string `json:"name"`
@@ -41,12 +80,94 @@ func InitGraphs() error {
 			event TEXT,
 			period TEXT,
 			length INTEGER,
-			createdOn TEXT
+			createdOn TEXT,
+			position INTEGER NOT NULL DEFAULT 0,
+			gridRow INTEGER NOT NULL DEFAULT 0,
+			gridColumn INTEGER NOT NULL DEFAULT 0,
+			width INTEGER NOT NULL DEFAULT %d,
+			height INTEGER NOT NULL DEFAULT %d
 		);`
-	_, err := db.Exec(query)
+	_, err := db.Exec(fmt.Sprintf(query, defaultGraphWidth, defaultGraphHeight))
 	return err
 }
 
+// MigrateGraphs adds the layout columns to graph tables created before they
+// existed. The graphs of every dashboard keep their order and are laid out
+// two per row.
+func MigrateGraphs() error {
+	exists, err := columnExists("graphs", "position")
+	if err != nil || exists {
+		return err
+	}
+
+	columns := []string{
+		"position INTEGER NOT NULL DEFAULT 0",
+		"gridRow INTEGER NOT NULL DEFAULT 0",
+		"gridColumn INTEGER NOT NULL DEFAULT 0",
+		fmt.Sprintf("width INTEGER NOT NULL DEFAULT %d", defaultGraphWidth),
+		fmt.Sprintf("height INTEGER NOT NULL DEFAULT %d", defaultGraphHeight),
+	}
+
+	for _, column := range columns {
+		_, err = db.Exec("ALTER TABLE graphs ADD COLUMN " + column)
+		if err != nil {
+			return err
+		}
+	}
+
+	rows, err := db.Query("select id, dashboardId from graphs order by dashboardId, id")
+	if err != nil {
+		return err
+	}
+
+	var graphs [][2]int64
+	for rows.Next() {
+		var graph [2]int64
+		err = rows.Scan(&graph[0], &graph[1])
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		graphs = append(graphs, graph)
+	}
+	rows.Close()
+
+	perRow := int64(dashboardColumns / defaultGraphWidth)
+
+	var dashboardId, position int64
+	for _, graph := range graphs {
+		if graph[1] != dashboardId {
+			dashboardId = graph[1]
+			position = 0
+		}
+
+		_, err = db.Exec("update graphs set position = ?, gridRow = ?, gridColumn = ? where id = ?",
+			position, position/perRow*defaultGraphHeight, position%perRow*defaultGraphWidth, graph[0])
+		if err != nil {
+			return err
+		}
+		position++
+	}
+
+	return nil
+}
+
+func scanGraph(row rowScanner) (Graph, error) {
+	var graph Graph
+	err := row.Scan(&graph.Id, &graph.DashboardId, &graph.Name, &graph.Event, &graph.Period, &graph.Length, &graph.CreatedOn,
+		&graph.Position, &graph.Row, &graph.Column, &graph.Width, &graph.Height)
+	return graph, err
+}
+
+// nextGraphPlacement returns the position and row of a graph added to a
+// dashboard, after and below all of its graphs
+func nextGraphPlacement(q queryRower, dashboardId int64) (int64, int64, error) {
+	var position, row int64
+	err := q.QueryRow("select coalesce(max(position) + 1, 0), coalesce(max(gridRow + height), 0) from graphs where dashboardId = ?",
+		dashboardId).Scan(&position, &row)
+	return position, row, err
+}
+
 func IsValidGraphId(graphId int64) (bool, error) {
 	var exists bool
 	query := `SELECT EXISTS (
@@ -70,41 +191,67 @@ func GetDashboardGraphs(dashboardId int64) ([]Graph, error) {
 		return graphs, errors.New("Invalid DashboardId")
 	}
 
-	rows, err := db.Query("select * from graphs where dashboardId = ?", dashboardId)
+	rows, err := db.Query("select "+graphColumns+" from graphs where dashboardId = ? order by position, id", dashboardId)
 	if err != nil {
 		return graphs, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var graph Graph
-		err := rows.Scan(&graph.Id, &graph.DashboardId, &graph.Name, &graph.Event, &graph.Period, &graph.Length, &graph.CreatedOn)
+		graph, err := scanGraph(rows)
 		if err != nil {
 			return graphs, err
 		}
 		graphs = append(graphs, graph)
 	}
 
+	for i := range graphs {
+		err = loadGraphEvent(&graphs[i])
+		if err != nil {
+			return graphs, err
+		}
+	}
+
 	return graphs, err
 }
 
 func GetGraph(graphId int64) (Graph, error) {
-	row := db.QueryRow("select * from graphs where id = ?", graphId)
+	row := db.QueryRow("select "+graphColumns+" from graphs where id = ?", graphId)
 
-	var graph Graph
-	err := row.Scan(&graph.Id, &graph.DashboardId, &graph.Name, &graph.Event, &graph.Period, &graph.Length, &graph.CreatedOn)
+	graph, err := scanGraph(row)
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return graph, errors.New("Invalid graphId")
 		}
+
+		return graph, err
 	}
 
+	err = loadGraphEvent(&graph)
 	return graph, err
 }
 
+// loadGraphEvent fills in the fields of a graph that come from its event,
+// a graph whose event has been deleted is marked as broken
+func loadGraphEvent(graph *Graph) error {
+	exists, err := IsValidEvent(graph.Event)
+	if err != nil {
+		return err
+	}
+
+	graph.Broken = !exists
+	if graph.Broken {
+		return nil
+	}
+
+	eventDef, err := GetEventDef(graph.Event)
+	graph.Unit = eventDef.Unit
+	return err
+}
+
 func UpdateGraph(graphId int64, updateGraph GraphUpdate) error {
-	_, err := GetGraph(graphId)
+	graph, err := GetGraph(graphId)
 	if err != nil {
 		return err
 	}
@@ -136,6 +283,15 @@ func UpdateGraph(graphId int64, updateGraph GraphUpdate) error {
 		return errors.New("Invalid length value")
 	}
 
+	if period == "" {
+		period = graph.Period
+	}
+
+	err = validateSeriesLength(period, length)
+	if err != nil {
+		return err
+	}
+
 	_, err = db.Exec(`
 		UPDATE graphs
 			set name = coalesce(NULLIF(?, ''), name),
@@ -167,51 +323,72 @@ func DeleteGraph(graphId int64) error {
 	return err
 }
 
-func CreateGraph(createGraph GraphCreate) (Graph, error) {
-	var graph Graph
-	dashboardId := createGraph.DashboardId
-	name := createGraph.Name
-	event := createGraph.Event
-	period := createGraph.Period
-	length := createGraph.Length
-
+// validateGraphDashboard checks the dashboard a graph is added to
+func validateGraphDashboard(dashboardId int64) error {
 	if dashboardId <= 0 {
-		return graph, errors.New("Invalid dashboardId")
+		return errors.New("Invalid dashboardId")
 	} else {
 		exists, _ := IsValidDashboard(dashboardId)
 		if !exists {
-			return graph, errors.New("Invalid dashboardId")
+			return errors.New("Invalid dashboardId")
 		}
 
 	}
 
+	return nil
+}
+
+// validateGraph checks the fields of a new graph
+func validateGraph(name string, event string, period string, length int64) error {
 	if name == "" {
-		return graph, errors.New("Invalid name")
+		return errors.New("Invalid name")
 	}
 
 	if event != "" {
 		exists, _ := IsValidEvent(event)
 		if !exists {
-			return graph, errors.New("Invalid event value")
+			return errors.New("Invalid event value")
 		}
 
 	} else {
-		return graph, errors.New("Event value cannot be empty")
+		return errors.New("Event value cannot be empty")
 
 	}
 
 	if period != "" {
 		if period != "DAILY" && period != "HOURLY" && period != "MINUTELY" {
-			return graph, errors.New("Invalid period value")
+			return errors.New("Invalid period value")
 		}
 
 	} else {
-		return graph, errors.New("Period cannot be empty")
+		return errors.New("Period cannot be empty")
 
 	}
 
-	if length <= 0 {
-		return graph, errors.New("Invalid length value")
+	return validateSeriesLength(period, length)
+}
+
+func CreateGraph(createGraph GraphCreate) (Graph, error) {
+	var graph Graph
+	dashboardId := createGraph.DashboardId
+	name := createGraph.Name
+	event := createGraph.Event
+	period := createGraph.Period
+	length := createGraph.Length
+
+	err := validateGraphDashboard(dashboardId)
+	if err != nil {
+		return graph, err
+	}
+
+	err = validateGraph(name, event, period, length)
+	if err != nil {
+		return graph, err
+	}
+
+	position, row, err := nextGraphPlacement(db, dashboardId)
+	if err != nil {
+		return graph, err
 	}
 
 	currentTime := time.Now()
@@ -219,10 +396,10 @@ func CreateGraph(createGraph GraphCreate) (Graph, error) {
 
 	result, err := db.Exec(
 		`
-		INSERT INTO graphs (dashboardId, name, event, period, length, createdOn)
-		values (?, ?, ?, ?, ?, ?)
+		INSERT INTO graphs (dashboardId, name, event, period, length, createdOn, position, gridRow)
+		values (?, ?, ?, ?, ?, ?, ?, ?)
 		`,
-		dashboardId, name, event, period, length, formattedTime)
+		dashboardId, name, event, period, length, formattedTime, position, row)
 
 	if err != nil {
 		return graph, err
@@ -246,6 +423,10 @@ func GetGraphData(graphId int64) ([]TimeStat, error) {
 		return statsArray, err
 	}
 
+	if graph.Broken {
+		return statsArray, errors.New("Graph event has been deleted")
+	}
+
 	event := graph.Event
 	period := graph.Period
 	length := graph.Length
@@ -253,3 +434,199 @@ func GetGraphData(graphId int64) ([]TimeStat, error) {
 	return GetEventData(event, period, length)
 
 }
+
+// CopyGraph adds a copy of a graph to a dashboard, which can be its own. The
+// copy keeps its size and is placed below the graphs of the dashboard.
+func CopyGraph(graphId int64, target GraphTarget) (Graph, error) {
+	graph, err := GetGraph(graphId)
+	if err != nil {
+		return graph, err
+	}
+
+	err = validateGraphDashboard(target.DashboardId)
+	if err != nil {
+		return graph, err
+	}
+
+	position, row, err := nextGraphPlacement(db, target.DashboardId)
+	if err != nil {
+		return graph, err
+	}
+
+	result, err := db.Exec(
+		`
+		INSERT INTO graphs (dashboardId, name, event, period, length, createdOn, position, gridRow, width, height)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`,
+		target.DashboardId, graph.Name, graph.Event, graph.Period, graph.Length, time.Now().Format("2006-01-02 15:04:05"),
+		position, row, graph.Width, graph.Height)
+	if err != nil {
+		return graph, err
+	}
+
+	copyId, err := result.LastInsertId()
+	if err != nil {
+		return graph, err
+	}
+
+	return GetGraph(copyId)
+}
+
+// MoveGraph moves a graph to another dashboard, below its graphs
+func MoveGraph(graphId int64, target GraphTarget) (Graph, error) {
+	graph, err := GetGraph(graphId)
+	if err != nil {
+		return graph, err
+	}
+
+	err = validateGraphDashboard(target.DashboardId)
+	if err != nil {
+		return graph, err
+	}
+
+	if graph.DashboardId == target.DashboardId {
+		return graph, nil
+	}
+
+	position, row, err := nextGraphPlacement(db, target.DashboardId)
+	if err != nil {
+		return graph, err
+	}
+
+	_, err = db.Exec("UPDATE graphs set dashboardId = ?, position = ?, gridRow = ?, gridColumn = 0 where id = ?",
+		target.DashboardId, position, row, graphId)
+	if err != nil {
+		return graph, err
+	}
+
+	return GetGraph(graphId)
+}
+
+func validateGraphLayout(layout GraphLayout) error {
+	if layout.Position < 0 {
+		return errors.New("Invalid position")
+	}
+
+	if layout.Width < 1 || layout.Width > dashboardColumns {
+		return errors.New("Invalid width")
+	}
+
+	if layout.Height < 1 || layout.Height > maxGraphHeight {
+		return errors.New("Invalid height")
+	}
+
+	if layout.Row < 0 {
+		return errors.New("Invalid row")
+	}
+
+	if layout.Column < 0 || layout.Column+layout.Width > dashboardColumns {
+		return errors.New("Invalid column")
+	}
+
+	return nil
+}
+
+func graphsOverlap(a GraphLayout, b GraphLayout) bool {
+	return a.Column < b.Column+b.Width && b.Column < a.Column+a.Width &&
+		a.Row < b.Row+b.Height && b.Row < a.Row+a.Height
+}
+
+// definitionLayout returns the layout of the graphs of a definition, with
+// their index as id, once it is checked like UpdateDashboardLayout does.
+// Definitions where no graph has a layout get the default placement and a
+// nil layout, a layout given for only some of the graphs is rejected.
+func definitionLayout(graphs []GraphDefinition) ([]GraphLayout, error) {
+	placed := slices.ContainsFunc(graphs, func(graph GraphDefinition) bool {
+		return graph.Width > 0
+	})
+	if !placed {
+		return nil, nil
+	}
+
+	var layouts []GraphLayout
+	for i, graph := range graphs {
+		if graph.Width <= 0 {
+			return nil, fmt.Errorf("Graph %s has no layout, every graph needs one when another has", graph.Name)
+		}
+
+		layout := GraphLayout{int64(i), int64(i), graph.Row, graph.Column, graph.Width, graph.Height}
+		err := validateGraphLayout(layout)
+		if err != nil {
+			return nil, fmt.Errorf("graph %s: %w", graph.Name, err)
+		}
+		layouts = append(layouts, layout)
+	}
+
+	for i, a := range layouts {
+		for _, b := range layouts[i+1:] {
+			if graphsOverlap(a, b) {
+				return nil, fmt.Errorf("Graphs %s and %s overlap", graphs[a.Id].Name, graphs[b.Id].Name)
+			}
+		}
+	}
+
+	return layouts, nil
+}
+
+// UpdateDashboardLayout places the listed graphs of a dashboard, the others
+// keep their place. The whole layout is rejected when a graph is not on the
+// dashboard, does not fit the grid or would overlap another graph.
+func UpdateDashboardLayout(dashboardId int64, layout DashboardLayout) (DashboardGet, error) {
+	dashboard, err := GetDashboard(dashboardId)
+	if err != nil {
+		return dashboard, err
+	}
+
+	layouts := make(map[int64]GraphLayout)
+	for _, graph := range dashboard.Graphs {
+		layouts[graph.Id] = GraphLayout{graph.Id, graph.Position, graph.Row, graph.Column, graph.Width, graph.Height}
+	}
+
+	updated := make(map[int64]bool)
+	for _, graphLayout := range layout.Graphs {
+		if _, ok := layouts[graphLayout.Id]; !ok {
+			return dashboard, fmt.Errorf("Graph %d is not on the dashboard", graphLayout.Id)
+		}
+
+		if updated[graphLayout.Id] {
+			return dashboard, fmt.Errorf("Graph %d is listed more than once", graphLayout.Id)
+		}
+		updated[graphLayout.Id] = true
+
+		err = validateGraphLayout(graphLayout)
+		if err != nil {
+			return dashboard, fmt.Errorf("graph %d: %w", graphLayout.Id, err)
+		}
+
+		layouts[graphLayout.Id] = graphLayout
+	}
+
+	for i, a := range dashboard.Graphs {
+		for _, b := range dashboard.Graphs[i+1:] {
+			if graphsOverlap(layouts[a.Id], layouts[b.Id]) {
+				return dashboard, fmt.Errorf("Graphs %d and %d overlap", a.Id, b.Id)
+			}
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return dashboard, err
+	}
+	defer tx.Rollback()
+
+	for _, graphLayout := range layout.Graphs {
+		_, err = tx.Exec("update graphs set position = ?, gridRow = ?, gridColumn = ?, width = ?, height = ? where id = ?",
+			graphLayout.Position, graphLayout.Row, graphLayout.Column, graphLayout.Width, graphLayout.Height, graphLayout.Id)
+		if err != nil {
+			return dashboard, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return dashboard, err
+	}
+
+	return GetDashboard(dashboardId)
+}
@@ -0,0 +1,262 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Table prefixes holding the aggregated counts of every event
+var eventPeriods = []string{"daily", "hourly", "minutely"}
+
+type EventRename struct {
+	Name string `json:"name"`
+}
+
+type EventMerge struct {
+	Into string `json:"into"`
+}
+
+// RenameEvent moves the definition and data of an event to a new name,
+// graphs, alerts and silences using the event follow the rename
+func RenameEvent(event string, name string) (EventDef, error) {
+	var eventDef EventDef
+
+	_, err := GetEventDef(event)
+	if err != nil {
+		return eventDef, err
+	}
+
+	if !IsValidEventName(name) {
+		return eventDef, errors.New("Invalid event name")
+	}
+
+	exists, err := IsValidEvent(name)
+	if err != nil {
+		return eventDef, err
+	}
+
+	if exists {
+		return eventDef, errors.New("Event already exists")
+	}
+
+	// Sightings batched in memory are recorded under the old name first
+	err = FlushEventStats()
+	if err != nil {
+		return eventDef, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return eventDef, err
+	}
+	defer tx.Rollback()
+
+	for _, period := range eventPeriods {
+		query := fmt.Sprintf("ALTER TABLE %s_%s RENAME TO %s_%s", period, event, period, name)
+		_, err = tx.Exec(query)
+		if err != nil {
+			return eventDef, err
+		}
+	}
+
+	_, err = tx.Exec("update events set event = ? where event = ?", name, event)
+	if err != nil {
+		return eventDef, err
+	}
+
+	_, err = tx.Exec("update graphs set event = ? where event = ?", name, event)
+	if err != nil {
+		return eventDef, err
+	}
+
+	_, err = tx.Exec("update alerts set event = ? where event = ?", name, event)
+	if err != nil {
+		return eventDef, err
+	}
+
+	_, err = tx.Exec("update silences set event = ? where event = ?", name, event)
+	if err != nil {
+		return eventDef, err
+	}
+
+	_, err = tx.Exec("update dimensions set event = ? where event = ?", name, event)
+	if err != nil {
+		return eventDef, err
+	}
+
+	_, err = tx.Exec("update relay_buckets set event = ? where event = ?", name, event)
+	if err != nil {
+		return eventDef, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return eventDef, err
+	}
+
+	return GetEventDef(name)
+}
+
+// MergeEvents adds the counts of event into the buckets of target and
+// removes event, graphs, alerts and silences using event are pointed at target
+func MergeEvents(event string, target string) (EventDef, error) {
+	var eventDef EventDef
+
+	_, err := GetEventDef(event)
+	if err != nil {
+		return eventDef, err
+	}
+
+	_, err = GetEventDef(target)
+	if err != nil {
+		return eventDef, errors.New("Invalid target event")
+	}
+
+	if event == target {
+		return eventDef, errors.New("Cannot merge an event into itself")
+	}
+
+	// Sightings batched in memory are recorded before they are merged
+	err = FlushEventStats()
+	if err != nil {
+		return eventDef, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return eventDef, err
+	}
+	defer tx.Rollback()
+
+	for _, period := range eventPeriods {
+		query := fmt.Sprintf(`
+			INSERT INTO %s_%s (time, count, samples, sum, min, max)
+			SELECT time, count, samples, sum, min, max FROM %s_%s WHERE true
+			ON CONFLICT(time) DO UPDATE SET
+				count = count + excluded.count,
+				samples = samples + excluded.samples,
+				sum = sum + excluded.sum,
+				min = min(coalesce(min, excluded.min), coalesce(excluded.min, min)),
+				max = max(coalesce(max, excluded.max), coalesce(excluded.max, max))`,
+			period, target, period, event)
+		_, err = tx.Exec(query)
+		if err != nil {
+			return eventDef, err
+		}
+
+		query = fmt.Sprintf("DROP TABLE %s_%s", period, event)
+		_, err = tx.Exec(query)
+		if err != nil {
+			return eventDef, err
+		}
+	}
+
+	_, err = tx.Exec(`
+		UPDATE events
+		set total = events.total + source.total,
+			firstSeen = min(coalesce(events.firstSeen, source.firstSeen), coalesce(source.firstSeen, events.firstSeen)),
+			lastSeen = max(coalesce(events.lastSeen, source.lastSeen), coalesce(source.lastSeen, events.lastSeen))
+		from (select total, firstSeen, lastSeen from events where event = ?) as source
+		where events.event = ?`,
+		event, target)
+	if err != nil {
+		return eventDef, err
+	}
+
+	_, err = tx.Exec("delete from events where event = ?", event)
+	if err != nil {
+		return eventDef, err
+	}
+
+	_, err = tx.Exec("update graphs set event = ? where event = ?", target, event)
+	if err != nil {
+		return eventDef, err
+	}
+
+	_, err = tx.Exec("update alerts set event = ? where event = ?", target, event)
+	if err != nil {
+		return eventDef, err
+	}
+
+	_, err = tx.Exec("update silences set event = ? where event = ?", target, event)
+	if err != nil {
+		return eventDef, err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO dimensions (event, period, time, key, value, count, samples, sum, min, max)
+		SELECT ?, period, time, key, value, count, samples, sum, min, max FROM dimensions WHERE event = ?
+		ON CONFLICT(event, period, time, key, value) DO UPDATE SET
+			count = count + excluded.count,
+			samples = samples + excluded.samples,
+			sum = sum + excluded.sum,
+			min = min(coalesce(min, excluded.min), coalesce(excluded.min, min)),
+			max = max(coalesce(max, excluded.max), coalesce(excluded.max, max))`,
+		target, event)
+	if err != nil {
+		return eventDef, err
+	}
+
+	_, err = tx.Exec("delete from dimensions where event = ?", event)
+	if err != nil {
+		return eventDef, err
+	}
+
+	_, err = tx.Exec("delete from relay_buckets where event = ?", event)
+	if err != nil {
+		return eventDef, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return eventDef, err
+	}
+
+	return GetEventDef(target)
+}
+
+// DeleteEvent removes an event with all its data. Graphs using the event
+// are kept and reported as broken.
+func DeleteEvent(event string) error {
+	_, err := GetEventDef(event)
+	if err != nil {
+		return err
+	}
+
+	// Sightings batched in memory would otherwise update a deleted event
+	err = FlushEventStats()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, period := range eventPeriods {
+		query := fmt.Sprintf("DROP TABLE IF EXISTS %s_%s", period, event)
+		_, err = tx.Exec(query)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec("delete from events where event = ?", event)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("delete from dimensions where event = ?", event)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("delete from relay_buckets where event = ?", event)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
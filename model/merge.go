@@ -0,0 +1,273 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+type DatabaseMerge struct {
+	Sources    []string
+	Into       string
+	Dashboards bool
+}
+
+// DatabaseMergeResult counts what one source database added to the target
+type DatabaseMergeResult struct {
+	Source     string
+	Events     int
+	NewEvents  int
+	Dashboards int
+	Graphs     int
+}
+
+// MergeDatabases adds the data of the source database files into the target
+// one, which is created when missing. Overlapping buckets are summed and
+// event definitions are united. Dashboards and their graphs are copied with
+// new ids when requested. The sources are not modified, a migrated copy of
+// each is merged instead.
+func MergeDatabases(merge DatabaseMerge) ([]DatabaseMergeResult, error) {
+	var results []DatabaseMergeResult
+
+	into, err := filepath.Abs(merge.Into)
+	if err != nil {
+		return results, err
+	}
+
+	for _, source := range merge.Sources {
+		sourcePath, err := filepath.Abs(source)
+		if err != nil {
+			return results, err
+		}
+
+		if sourcePath == into {
+			return results, errors.New("A database cannot be merged into itself")
+		}
+
+		ok, err := exists(sourcePath)
+		if err != nil {
+			return results, err
+		}
+		if !ok {
+			return results, fmt.Errorf("Database %s does not exist", source)
+		}
+	}
+
+	tmpDir, err := os.MkdirTemp("", "minim-merge")
+	if err != nil {
+		return results, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	for i, source := range merge.Sources {
+		copyPath := filepath.Join(tmpDir, fmt.Sprintf("source-%d.db", i))
+
+		err = copyDatabase(source, copyPath)
+		if err != nil {
+			return results, fmt.Errorf("%s: %w", source, err)
+		}
+
+		restore, err := useDatabase(copyPath)
+		if err != nil {
+			return results, fmt.Errorf("%s: %w", source, err)
+		}
+		restore()
+	}
+
+	restore, err := useDatabase(into)
+	if err != nil {
+		return results, err
+	}
+	defer restore()
+
+	for i, source := range merge.Sources {
+		copyPath := filepath.Join(tmpDir, fmt.Sprintf("source-%d.db", i))
+
+		result, err := mergeDatabase(copyPath, merge.Dashboards)
+		result.Source = source
+		results = append(results, result)
+		if err != nil {
+			return results, fmt.Errorf("%s: %w", source, err)
+		}
+	}
+
+	return results, nil
+}
+
+// copyDatabase writes a consistent copy of the database at source to path
+// without modifying it
+func copyDatabase(source string, path string) error {
+	sourceDb, err := sql.Open("sqlite3", "file:"+source+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer sourceDb.Close()
+
+	_, err = sourceDb.Exec("VACUUM INTO ?", path)
+	return err
+}
+
+// mergeDatabase adds the database at path, already migrated, into the
+// current one
+func mergeDatabase(path string, dashboards bool) (DatabaseMergeResult, error) {
+	var result DatabaseMergeResult
+
+	_, err := db.Exec("ATTACH DATABASE ? AS source", path)
+	if err != nil {
+		return result, err
+	}
+	defer db.Exec("DETACH DATABASE source")
+
+	rows, err := db.Query("select event from source.events")
+	if err != nil {
+		return result, err
+	}
+
+	var events []string
+	for rows.Next() {
+		var event string
+		err = rows.Scan(&event)
+		if err != nil {
+			rows.Close()
+			return result, err
+		}
+		events = append(events, event)
+	}
+	rows.Close()
+
+	// Tables are created before the transaction as CreateEventDef runs its
+	// own statements
+	for _, event := range events {
+		known, err := IsValidEvent(event)
+		if err != nil {
+			return result, err
+		}
+
+		if !known {
+			_, err = CreateEventDef(event)
+			if err != nil {
+				return result, fmt.Errorf("%s: %w", event, err)
+			}
+			result.NewEvents++
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	for _, event := range events {
+		for _, period := range eventPeriods {
+			query := fmt.Sprintf(`
+				INSERT INTO main.%s_%s (time, count, samples, sum, min, max)
+				SELECT time, count, samples, sum, min, max FROM source.%s_%s WHERE true
+				ON CONFLICT(time) DO UPDATE SET
+					count = count + excluded.count,
+					samples = samples + excluded.samples,
+					sum = sum + excluded.sum,
+					min = min(coalesce(min, excluded.min), coalesce(excluded.min, min)),
+					max = max(coalesce(max, excluded.max), coalesce(excluded.max, max))`,
+				period, event, period, event)
+			_, err = tx.Exec(query)
+			if err != nil {
+				return result, fmt.Errorf("%s: %w", event, err)
+			}
+		}
+
+		// Metadata of the target wins, empty fields are filled from the source
+		_, err = tx.Exec(`
+			UPDATE main.events
+			set total = events.total + incoming.total,
+				firstSeen = min(coalesce(events.firstSeen, incoming.firstSeen), coalesce(incoming.firstSeen, events.firstSeen)),
+				lastSeen = max(coalesce(events.lastSeen, incoming.lastSeen), coalesce(incoming.lastSeen, events.lastSeen)),
+				description = coalesce(nullif(events.description, ''), incoming.description),
+				unit = coalesce(nullif(events.unit, ''), incoming.unit),
+				tags = coalesce(nullif(events.tags, ''), incoming.tags),
+				owner = coalesce(nullif(events.owner, ''), incoming.owner)
+			from (select total, firstSeen, lastSeen, description, unit, tags, owner from source.events where event = ?) as incoming
+			where events.event = ?`,
+			event, event)
+		if err != nil {
+			return result, fmt.Errorf("%s: %w", event, err)
+		}
+
+		result.Events++
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO main.dimensions (event, period, time, key, value, count, samples, sum, min, max)
+		SELECT event, period, time, key, value, count, samples, sum, min, max FROM source.dimensions WHERE true
+		ON CONFLICT(event, period, time, key, value) DO UPDATE SET
+			count = count + excluded.count,
+			samples = samples + excluded.samples,
+			sum = sum + excluded.sum,
+			min = min(coalesce(min, excluded.min), coalesce(excluded.min, min)),
+			max = max(coalesce(max, excluded.max), coalesce(excluded.max, max))`)
+	if err != nil {
+		return result, err
+	}
+
+	if dashboards {
+		result.Dashboards, result.Graphs, err = mergeDashboards(tx)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, tx.Commit()
+}
+
+// mergeDashboards copies the dashboards of the source database with their
+// graphs, which are pointed at the new dashboard ids
+func mergeDashboards(tx *sql.Tx) (int, int, error) {
+	rows, err := tx.Query("select id, name, createdOn from source.dashboards order by id")
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var dashboards []Dashboard
+	for rows.Next() {
+		var dashboard Dashboard
+		err = rows.Scan(&dashboard.Id, &dashboard.Name, &dashboard.CreatedOn)
+		if err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		dashboards = append(dashboards, dashboard)
+	}
+	rows.Close()
+
+	graphCount := 0
+	for _, dashboard := range dashboards {
+		res, err := tx.Exec("insert into main.dashboards (name, createdOn) values (?, ?)", dashboard.Name, dashboard.CreatedOn)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		dashboardId, err := res.LastInsertId()
+		if err != nil {
+			return 0, 0, err
+		}
+
+		res, err = tx.Exec(`
+			insert into main.graphs (dashboardId, name, event, period, length, createdOn, position, gridRow, gridColumn, width, height)
+			select ?, name, event, period, length, createdOn, position, gridRow, gridColumn, width, height
+			from source.graphs where dashboardId = ? order by position, id`,
+			dashboardId, dashboard.Id)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		graphs, err := res.RowsAffected()
+		if err != nil {
+			return 0, 0, err
+		}
+		graphCount += int(graphs)
+	}
+
+	return len(dashboards), graphCount, nil
+}
@@ -0,0 +1,77 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// EventCounts holds the counts of the current minute, hour and day buckets
+// of an event along with its all-time total
+type EventCounts struct {
+	Event  string
+	Minute int64
+	Hour   int64
+	Day    int64
+	Total  int64
+}
+
+var dbErrors atomic.Int64
+
+// CountDbError counts err towards the SQLite errors exported as metrics,
+// errors that did not come from SQLite are ignored
+func CountDbError(err error) {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		dbErrors.Add(1)
+	}
+}
+
+func GetDbErrorCount() int64 {
+	return dbErrors.Load()
+}
+
+func getBucketCount(period string, event string, bucket time.Time) (int64, error) {
+	var count int64
+
+	query := fmt.Sprintf("select coalesce(sum(count), 0) from %s_%s where time = ?", period, event)
+	err := db.QueryRow(query, bucket.Unix()).Scan(&count)
+
+	return count, err
+}
+
+func GetEventCounts() ([]EventCounts, error) {
+	var eventCounts []EventCounts
+
+	eventDefs, err := GetEventDefs(EventDefQuery{Sort: "event"})
+	if err != nil {
+		return eventCounts, err
+	}
+
+	currentTime := time.Now()
+	buckets := []time.Time{
+		currentTime.Truncate(time.Minute),
+		currentTime.Truncate(time.Hour),
+		time.Date(currentTime.Year(), currentTime.Month(), currentTime.Day(), 0, 0, 0, 0, currentTime.Location()),
+	}
+
+	for _, eventDef := range eventDefs {
+		counts := EventCounts{Event: eventDef.Event, Total: eventDef.Total}
+		values := []*int64{&counts.Minute, &counts.Hour, &counts.Day}
+
+		for i, period := range []string{"minutely", "hourly", "daily"} {
+			*values[i], err = getBucketCount(period, eventDef.Event, buckets[i])
+			if err != nil {
+				CountDbError(err)
+				return eventCounts, err
+			}
+		}
+
+		eventCounts = append(eventCounts, counts)
+	}
+
+	return eventCounts, nil
+}
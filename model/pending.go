@@ -0,0 +1,133 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type PendingEvent struct {
+	Event     string `json:"event"`
+	Count     int64  `json:"count"`
+	FirstSeen string `json:"firstSeen"`
+	LastSeen  string `json:"lastSeen"`
+}
+
+func InitPendingEvents() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS pending_events (
+			event TEXT PRIMARY KEY,
+			count INTEGER,
+			firstSeen TEXT,
+			lastSeen TEXT
+		);`
+
+	_, err := db.Exec(query)
+	return err
+}
+
+func GetPendingEvents() ([]PendingEvent, error) {
+	var pendingEvents []PendingEvent
+
+	rows, err := db.Query("select event, count, firstSeen, lastSeen from pending_events order by count desc")
+	if err != nil {
+		return pendingEvents, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var pendingEvent PendingEvent
+		err := rows.Scan(&pendingEvent.Event, &pendingEvent.Count, &pendingEvent.FirstSeen, &pendingEvent.LastSeen)
+		if err != nil {
+			return pendingEvents, err
+		}
+		pendingEvents = append(pendingEvents, pendingEvent)
+	}
+
+	return pendingEvents, nil
+}
+
+func CountPendingEvents() (int64, error) {
+	var count int64
+	err := db.QueryRow("select count(*) from pending_events").Scan(&count)
+	return count, err
+}
+
+func IsPendingEvent(event string) (bool, error) {
+	var exists bool
+	query := `SELECT EXISTS (
+		SELECT 1
+		FROM pending_events
+		WHERE event = ?
+	);`
+
+	err := db.QueryRow(query, event).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+// SubmitPendingEvent counts an occurrence of an event that is waiting for
+// approval, no event tables are created until it is approved
+func SubmitPendingEvent(event string) error {
+	if !IsValidEventName(event) {
+		return errors.New("Invalid event name")
+	}
+
+	currentTime := time.Now()
+	formattedTime := currentTime.Format("2006-01-02 15:04:05")
+
+	_, err := db.Exec(`
+		INSERT INTO pending_events (event, count, firstSeen, lastSeen)
+		values (?, 1, ?, ?)
+		ON CONFLICT(event) DO UPDATE SET
+			count = count + 1,
+			lastSeen = excluded.lastSeen`,
+		event, formattedTime, formattedTime)
+
+	return err
+}
+
+func getPendingEvent(event string) (PendingEvent, error) {
+	row := db.QueryRow("select event, count, firstSeen, lastSeen from pending_events where event = ?", event)
+
+	var pendingEvent PendingEvent
+	err := row.Scan(&pendingEvent.Event, &pendingEvent.Count, &pendingEvent.FirstSeen, &pendingEvent.LastSeen)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return pendingEvent, errors.New("Invalid pending event")
+		}
+	}
+
+	return pendingEvent, err
+}
+
+func ApprovePendingEvent(event string) (EventDef, error) {
+	var eventDef EventDef
+
+	_, err := getPendingEvent(event)
+	if err != nil {
+		return eventDef, err
+	}
+
+	// Approved events count towards the same limit as the ones created
+	// when first seen
+	eventDef, err = CreateLimitedEventDef(event)
+	if err != nil {
+		return eventDef, err
+	}
+
+	_, err = db.Exec("delete from pending_events where event = ?", event)
+	return eventDef, err
+}
+
+func RejectPendingEvent(event string) error {
+	_, err := getPendingEvent(event)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("delete from pending_events where event = ?", event)
+	return err
+}
@@ -0,0 +1,314 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// RelayBucket is a bucket forwarded by another instance with its absolute
+// values. Key and Value are empty for the buckets of the event itself and
+// name the dimension otherwise.
+type RelayBucket struct {
+	Event   string   `json:"event"`
+	Period  string   `json:"period"`
+	Time    int64    `json:"time"`
+	Key     string   `json:"key,omitempty"`
+	Value   string   `json:"value,omitempty"`
+	Count   int64    `json:"count"`
+	Samples int64    `json:"samples"`
+	Sum     float64  `json:"sum"`
+	Min     *float64 `json:"min"`
+	Max     *float64 `json:"max"`
+}
+
+// Dimension key recording the instance relayed buckets come from
+const relayHostKey = "host"
+
+func InitRelay() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS relay_buckets (
+			origin TEXT NOT NULL,
+			event TEXT NOT NULL,
+			period TEXT NOT NULL,
+			time INTEGER NOT NULL,
+			key TEXT NOT NULL,
+			value TEXT NOT NULL,
+			count INTEGER NOT NULL DEFAULT 0,
+			samples INTEGER NOT NULL DEFAULT 0,
+			sum REAL NOT NULL DEFAULT 0,
+			min REAL,
+			max REAL,
+			PRIMARY KEY (origin, event, period, time, key, value)
+		);
+
+		CREATE TABLE IF NOT EXISTS relay_cursors (
+			target TEXT PRIMARY KEY,
+			time INTEGER NOT NULL
+		);`
+
+	_, err := db.Exec(query)
+	return err
+}
+
+// GetRelayCursor returns the time of the last successful forward to target,
+// zero when nothing has been forwarded yet
+func GetRelayCursor(target string) (time.Time, error) {
+	var cursor int64
+	err := db.QueryRow("select time from relay_cursors where target = ?", target).Scan(&cursor)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Unix(cursor, 0), nil
+}
+
+func SetRelayCursor(target string, cursor time.Time) error {
+	_, err := db.Exec(`
+		INSERT INTO relay_cursors (target, time) values (?, ?)
+		ON CONFLICT(target) DO UPDATE SET time = excluded.time`,
+		target, cursor.Unix())
+	return err
+}
+
+// GetRelayBuckets returns the event and dimension buckets from the one
+// containing since onwards. A zero since returns every bucket.
+func GetRelayBuckets(since time.Time) ([]RelayBucket, error) {
+	var buckets []RelayBucket
+
+	rows, err := db.Query("select event from events")
+	if err != nil {
+		return buckets, err
+	}
+
+	var events []string
+	for rows.Next() {
+		var event string
+		err = rows.Scan(&event)
+		if err != nil {
+			rows.Close()
+			return buckets, err
+		}
+		events = append(events, event)
+	}
+	rows.Close()
+
+	for _, period := range eventPeriods {
+		var start int64
+		if !since.IsZero() {
+			start = bucketStart(period, since).Unix()
+		}
+
+		for _, event := range events {
+			query := fmt.Sprintf("select time, count, samples, sum, min, max from %s_%s where time >= ?", period, event)
+			rows, err := db.Query(query, start)
+			if err != nil {
+				return buckets, err
+			}
+
+			for rows.Next() {
+				bucket := RelayBucket{Event: event, Period: period}
+				err = rows.Scan(&bucket.Time, &bucket.Count, &bucket.Samples, &bucket.Sum, &bucket.Min, &bucket.Max)
+				if err != nil {
+					rows.Close()
+					return buckets, err
+				}
+				buckets = append(buckets, bucket)
+			}
+			rows.Close()
+		}
+
+		rows, err := db.Query(`
+			select event, time, key, value, count, samples, sum, min, max from dimensions
+			where period = ? and time >= ?`,
+			period, start)
+		if err != nil {
+			return buckets, err
+		}
+
+		for rows.Next() {
+			bucket := RelayBucket{Period: period}
+			err = rows.Scan(&bucket.Event, &bucket.Time, &bucket.Key, &bucket.Value, &bucket.Count, &bucket.Samples, &bucket.Sum, &bucket.Min, &bucket.Max)
+			if err != nil {
+				rows.Close()
+				return buckets, err
+			}
+			buckets = append(buckets, bucket)
+		}
+		rows.Close()
+	}
+
+	return buckets, nil
+}
+
+func sameFloat(a *float64, b *float64) bool {
+	return a == nil && b == nil || a != nil && b != nil && *a == *b
+}
+
+// MergeRelayBuckets records buckets forwarded by origin. The last version of
+// every bucket received from an origin is kept and only the difference with
+// it is added, so a bucket sent again is not counted twice. A bucket lower
+// than its last version, e.g. after the origin lost its database, is a reset:
+// it is stored and nothing is added. With
+// hostDimension the event buckets are also broken down by origin under the
+// host dimension. The events must exist.
+func MergeRelayBuckets(origin string, buckets []RelayBucket, hostDimension bool) error {
+	maxKeysConfig, _ := GetConfigValue("MAX_DIMENSION_KEYS")
+	maxKeys, _ := strconv.Atoi(maxKeysConfig)
+
+	maxValuesConfig, _ := GetConfigValue("MAX_DIMENSION_VALUES")
+	maxValues, _ := strconv.Atoi(maxValuesConfig)
+
+	type sighting struct {
+		event string
+		count int64
+		time  time.Time
+	}
+	var sightings []sighting
+
+	tx, err := db.Begin()
+	if err != nil {
+		CountDbError(err)
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, bucket := range buckets {
+		if !slices.Contains(eventPeriods, bucket.Period) {
+			return errors.New("Invalid period")
+		}
+
+		var previous RelayBucket
+		err = tx.QueryRow(`
+			select count, samples, sum, min, max from relay_buckets
+			where origin = ? and event = ? and period = ? and time = ? and key = ? and value = ?`,
+			origin, bucket.Event, bucket.Period, bucket.Time, bucket.Key, bucket.Value).
+			Scan(&previous.Count, &previous.Samples, &previous.Sum, &previous.Min, &previous.Max)
+		if err != nil && err != sql.ErrNoRows {
+			CountDbError(err)
+			return err
+		}
+
+		found := err == nil
+		if found && previous.Count == bucket.Count && previous.Samples == bucket.Samples &&
+			previous.Sum == bucket.Sum && sameFloat(previous.Min, bucket.Min) && sameFloat(previous.Max, bucket.Max) {
+			continue
+		}
+
+		_, err = tx.Exec(`
+			INSERT INTO relay_buckets (origin, event, period, time, key, value, count, samples, sum, min, max)
+			values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(origin, event, period, time, key, value) DO UPDATE SET
+				count = excluded.count,
+				samples = excluded.samples,
+				sum = excluded.sum,
+				min = excluded.min,
+				max = excluded.max`,
+			origin, bucket.Event, bucket.Period, bucket.Time, bucket.Key, bucket.Value,
+			bucket.Count, bucket.Samples, bucket.Sum, bucket.Min, bucket.Max)
+		if err != nil {
+			CountDbError(err)
+			return err
+		}
+
+		if found && (bucket.Count < previous.Count || bucket.Samples < previous.Samples || bucket.Sum < previous.Sum) {
+			continue
+		}
+
+		count := bucket.Count - previous.Count
+		samples := bucket.Samples - previous.Samples
+		sum := bucket.Sum - previous.Sum
+
+		var dimensions [][2]string
+		if bucket.Key != "" {
+			dimensions = append(dimensions, [2]string{bucket.Key, bucket.Value})
+		} else {
+			query := fmt.Sprintf(`
+				INSERT INTO %s_%s (time, count, samples, sum, min, max) values (?, ?, ?, ?, ?, ?)
+				ON CONFLICT(time) DO UPDATE SET
+					count = count + excluded.count,
+					samples = samples + excluded.samples,
+					sum = sum + excluded.sum,
+					min = min(coalesce(min, excluded.min), coalesce(excluded.min, min)),
+					max = max(coalesce(max, excluded.max), coalesce(excluded.max, max))`,
+				bucket.Period, bucket.Event)
+			_, err = tx.Exec(query, bucket.Time, count, samples, sum, bucket.Min, bucket.Max)
+			if err != nil {
+				CountDbError(err)
+				return err
+			}
+
+			if hostDimension {
+				dimensions = append(dimensions, [2]string{relayHostKey, origin})
+			}
+
+			// Totals come from the daily buckets only, the minutely ones
+			// give a more precise last seen time
+			switch bucket.Period {
+			case "daily":
+				sightings = append(sightings, sighting{bucket.Event, count, time.Unix(bucket.Time, 0)})
+			case "minutely":
+				if count > 0 {
+					sightings = append(sightings, sighting{bucket.Event, 0, time.Unix(bucket.Time, 0)})
+				}
+			}
+		}
+
+		for _, dimension := range dimensions {
+			if len(dimension[0]) > maxDimensionKeyLength || len(dimension[1]) > maxDimensionValueLength {
+				continue
+			}
+
+			ok, err := isKnownDimensionValue(tx, bucket.Event, dimension[0], dimension[1], maxKeys, maxValues)
+			if err != nil {
+				CountDbError(err)
+				return err
+			}
+
+			if !ok {
+				continue
+			}
+
+			_, err = tx.Exec(`
+				INSERT INTO dimensions (event, period, time, key, value, count, samples, sum, min, max)
+				values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+				ON CONFLICT(event, period, time, key, value) DO UPDATE SET
+					count = count + excluded.count,
+					samples = samples + excluded.samples,
+					sum = sum + excluded.sum,
+					min = min(coalesce(min, excluded.min), coalesce(excluded.min, min)),
+					max = max(coalesce(max, excluded.max), coalesce(excluded.max, max))`,
+				bucket.Event, bucket.Period, bucket.Time, dimension[0], dimension[1], count, samples, sum, bucket.Min, bucket.Max)
+			if err != nil {
+				CountDbError(err)
+				return err
+			}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		CountDbError(err)
+		return err
+	}
+
+	for _, seen := range sightings {
+		RecordEventSeen(seen.event, seen.count, seen.time)
+	}
+
+	return nil
+}
+
+// pruneRelayBuckets applies the retention of the minutely and hourly event
+// tables to the relayed buckets
+func pruneRelayBuckets(minutelyCutoff int64, hourlyCutoff int64) error {
+	_, err := db.Exec("delete from relay_buckets where (period = 'minutely' and time < ?) or (period = 'hourly' and time < ?)",
+		minutelyCutoff, hourlyCutoff)
+	return err
+}
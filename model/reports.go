@@ -0,0 +1,223 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type Report struct {
+	Id          int64   `json:"id"`
+	Name        string  `json:"name"`
+	DashboardId int64   `json:"dashboardId"`
+	ChannelId   int64   `json:"channelId"`
+	Schedule    string  `json:"schedule"`
+	LastRun     *string `json:"lastRun"`
+	CreatedOn   string  `json:"createdOn"`
+}
+
+type ReportCreate struct {
+	Name        string `json:"name"`
+	DashboardId int64  `json:"dashboardId"`
+	ChannelId   int64  `json:"channelId"`
+	Schedule    string `json:"schedule"`
+}
+
+type ReportUpdate struct {
+	Name        string `json:"name"`
+	DashboardId int64  `json:"dashboardId"`
+	ChannelId   int64  `json:"channelId"`
+	Schedule    string `json:"schedule"`
+}
+
+const reportColumns = "id, name, dashboardId, channelId, schedule, lastRun, createdOn"
+
+func InitReports() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS reports (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT,
+			dashboardId INTEGER NOT NULL,
+			channelId INTEGER NOT NULL,
+			schedule TEXT,
+			lastRun TEXT,
+			createdOn TEXT
+		);`
+
+	_, err := db.Exec(query)
+	return err
+}
+
+func scanReport(row rowScanner) (Report, error) {
+	var report Report
+	err := row.Scan(&report.Id, &report.Name, &report.DashboardId, &report.ChannelId, &report.Schedule,
+		&report.LastRun, &report.CreatedOn)
+
+	return report, err
+}
+
+func GetReports() ([]Report, error) {
+	var reports []Report
+
+	rows, err := db.Query("select " + reportColumns + " from reports")
+	if err != nil {
+		return reports, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return reports, err
+		}
+		reports = append(reports, report)
+	}
+
+	return reports, nil
+}
+
+func GetReport(reportId int64) (Report, error) {
+	row := db.QueryRow("select "+reportColumns+" from reports where id = ?", reportId)
+
+	report, err := scanReport(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return report, errors.New("Invalid reportId")
+		}
+	}
+
+	return report, err
+}
+
+func validateReport(report Report) error {
+	if report.Name == "" {
+		return errors.New("Invalid name")
+	}
+
+	exists, _ := IsValidDashboard(report.DashboardId)
+	if !exists {
+		return errors.New("Invalid dashboardId")
+	}
+
+	channel, err := GetChannel(report.ChannelId)
+	if err != nil {
+		return err
+	}
+
+	if channel.Type != ChannelEmail {
+		return errors.New("Reports can only be sent to email channels")
+	}
+
+	_, err = ParseSchedule(report.Schedule)
+	return err
+}
+
+func CreateReport(createReport ReportCreate) (Report, error) {
+	report := Report{
+		Name:        createReport.Name,
+		DashboardId: createReport.DashboardId,
+		ChannelId:   createReport.ChannelId,
+		Schedule:    createReport.Schedule,
+	}
+
+	err := validateReport(report)
+	if err != nil {
+		return report, err
+	}
+
+	currentTime := time.Now()
+	formattedTime := currentTime.Format("2006-01-02 15:04:05")
+
+	result, err := db.Exec(
+		`
+		INSERT INTO reports (name, dashboardId, channelId, schedule, createdOn)
+		values (?, ?, ?, ?, ?)
+		`,
+		report.Name, report.DashboardId, report.ChannelId, report.Schedule, formattedTime)
+	if err != nil {
+		return report, err
+	}
+
+	reportId, err := result.LastInsertId()
+	if err != nil {
+		return report, err
+	}
+
+	return GetReport(reportId)
+}
+
+func UpdateReport(reportId int64, updateReport ReportUpdate) (Report, error) {
+	report, err := GetReport(reportId)
+	if err != nil {
+		return report, err
+	}
+
+	if updateReport.Name != "" {
+		report.Name = updateReport.Name
+	}
+
+	if updateReport.DashboardId != 0 {
+		report.DashboardId = updateReport.DashboardId
+	}
+
+	if updateReport.ChannelId != 0 {
+		report.ChannelId = updateReport.ChannelId
+	}
+
+	if updateReport.Schedule != "" {
+		report.Schedule = updateReport.Schedule
+	}
+
+	err = validateReport(report)
+	if err != nil {
+		return report, err
+	}
+
+	_, err = db.Exec(`
+		UPDATE reports
+		set name = ?, dashboardId = ?, channelId = ?, schedule = ?
+		where id = ?`,
+		report.Name, report.DashboardId, report.ChannelId, report.Schedule, reportId)
+	if err != nil {
+		return report, err
+	}
+
+	return GetReport(reportId)
+}
+
+func DeleteReport(reportId int64) error {
+	_, err := GetReport(reportId)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM reports where id = ?", reportId)
+	return err
+}
+
+func SetReportLastRun(reportId int64, lastRun time.Time) error {
+	_, err := db.Exec("update reports set lastRun = ? where id = ?", lastRun.Format("2006-01-02 15:04:05"), reportId)
+	return err
+}
+
+// IsReportDue reports whether a scheduled run of the report has passed
+// since it last ran, or since it was created if it never ran
+func IsReportDue(report Report, now time.Time) (bool, error) {
+	schedule, err := ParseSchedule(report.Schedule)
+	if err != nil {
+		return false, err
+	}
+
+	since := report.CreatedOn
+	if report.LastRun != nil {
+		since = *report.LastRun
+	}
+
+	sinceTime, err := time.ParseInLocation("2006-01-02 15:04:05", since, time.Local)
+	if err != nil {
+		return false, err
+	}
+
+	next := schedule.Next(sinceTime)
+	return !next.IsZero() && !next.After(now), nil
+}
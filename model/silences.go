@@ -0,0 +1,285 @@
+package model
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// A Silence mutes the notifications of alerts matching its event and/or
+// alert. One-off silences are active between StartsAt and EndsAt, recurring
+// maintenance windows are active for Minutes after every run of Schedule.
+type Silence struct {
+	Id        int64   `json:"id"`
+	Event     string  `json:"event"`
+	AlertId   int64   `json:"alertId"`
+	Comment   string  `json:"comment"`
+	StartsAt  *string `json:"startsAt"`
+	EndsAt    *string `json:"endsAt"`
+	Schedule  string  `json:"schedule"`
+	Minutes   int64   `json:"minutes"`
+	Active    bool    `json:"active"`
+	CreatedOn string  `json:"createdOn"`
+}
+
+type SilenceCreate struct {
+	Event    string `json:"event"`
+	AlertId  int64  `json:"alertId"`
+	Comment  string `json:"comment"`
+	StartsAt string `json:"startsAt"`
+	EndsAt   string `json:"endsAt"`
+	Schedule string `json:"schedule"`
+	Minutes  int64  `json:"minutes"`
+}
+
+// Longest silence in minutes, 7 days. Recurring windows are checked minute
+// by minute on every evaluation so they have to stay bounded.
+const maxSilenceMinutes = 7 * 24 * 60
+
+const silenceColumns = "id, event, alertId, comment, startsAt, endsAt, schedule, minutes, createdOn"
+
+func InitSilences() error {
+	query := `
+		CREATE TABLE IF NOT EXISTS silences (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			event TEXT NOT NULL DEFAULT '',
+			alertId INTEGER NOT NULL DEFAULT 0,
+			comment TEXT,
+			startsAt TEXT,
+			endsAt TEXT,
+			schedule TEXT NOT NULL DEFAULT '',
+			minutes INTEGER NOT NULL DEFAULT 0,
+			createdOn TEXT
+		);`
+
+	_, err := db.Exec(query)
+	return err
+}
+
+func scanSilence(row rowScanner) (Silence, error) {
+	var silence Silence
+	err := row.Scan(&silence.Id, &silence.Event, &silence.AlertId, &silence.Comment, &silence.StartsAt,
+		&silence.EndsAt, &silence.Schedule, &silence.Minutes, &silence.CreatedOn)
+
+	return silence, err
+}
+
+func GetSilences() ([]Silence, error) {
+	var silences []Silence
+
+	rows, err := db.Query("select " + silenceColumns + " from silences")
+	if err != nil {
+		return silences, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	for rows.Next() {
+		silence, err := scanSilence(rows)
+		if err != nil {
+			return silences, err
+		}
+
+		silence.Active = silence.isActive(now)
+		silences = append(silences, silence)
+	}
+
+	return silences, nil
+}
+
+func GetSilence(silenceId int64) (Silence, error) {
+	row := db.QueryRow("select "+silenceColumns+" from silences where id = ?", silenceId)
+
+	silence, err := scanSilence(row)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return silence, errors.New("Invalid silenceId")
+		}
+		return silence, err
+	}
+
+	silence.Active = silence.isActive(time.Now())
+	return silence, nil
+}
+
+func parseSilenceTime(value string) (time.Time, error) {
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", value, time.Local)
+	if err != nil {
+		return t, errors.New("Times must be formatted as 2006-01-02 15:04:05")
+	}
+
+	return t, nil
+}
+
+func CreateSilence(createSilence SilenceCreate) (Silence, error) {
+	var silence Silence
+
+	if createSilence.Event == "" && createSilence.AlertId == 0 {
+		return silence, errors.New("A silence needs an event or an alertId")
+	}
+
+	if createSilence.Event != "" && !IsValidEventName(createSilence.Event) {
+		return silence, errors.New("Invalid event")
+	}
+
+	if createSilence.AlertId != 0 {
+		_, err := GetAlert(createSilence.AlertId)
+		if err != nil {
+			return silence, err
+		}
+	}
+
+	if createSilence.Minutes < 0 {
+		return silence, errors.New("Invalid minutes")
+	}
+
+	if createSilence.Minutes > maxSilenceMinutes {
+		return silence, fmt.Errorf("A silence cannot last more than %d minutes", maxSilenceMinutes)
+	}
+
+	currentTime := time.Now()
+	formattedTime := currentTime.Format("2006-01-02 15:04:05")
+
+	var startsAt, endsAt *string
+	if createSilence.Schedule != "" {
+		_, err := ParseSchedule(createSilence.Schedule)
+		if err != nil {
+			return silence, err
+		}
+
+		if createSilence.Minutes == 0 {
+			return silence, errors.New("A recurring silence needs minutes")
+		}
+	} else {
+		start := currentTime
+		if createSilence.StartsAt != "" {
+			var err error
+			start, err = parseSilenceTime(createSilence.StartsAt)
+			if err != nil {
+				return silence, err
+			}
+		}
+
+		var end time.Time
+		if createSilence.EndsAt != "" {
+			var err error
+			end, err = parseSilenceTime(createSilence.EndsAt)
+			if err != nil {
+				return silence, err
+			}
+		} else if createSilence.Minutes > 0 {
+			end = start.Add(time.Duration(createSilence.Minutes) * time.Minute)
+		} else {
+			return silence, errors.New("A silence needs an endsAt or minutes")
+		}
+
+		if !end.After(start) {
+			return silence, errors.New("endsAt must be after startsAt")
+		}
+
+		formattedStart := start.Format("2006-01-02 15:04:05")
+		formattedEnd := end.Format("2006-01-02 15:04:05")
+		startsAt, endsAt = &formattedStart, &formattedEnd
+	}
+
+	result, err := db.Exec(
+		`
+		INSERT INTO silences (event, alertId, comment, startsAt, endsAt, schedule, minutes, createdOn)
+		values (?, ?, ?, ?, ?, ?, ?, ?)
+		`,
+		createSilence.Event, createSilence.AlertId, createSilence.Comment, startsAt, endsAt,
+		createSilence.Schedule, createSilence.Minutes, formattedTime)
+	if err != nil {
+		return silence, err
+	}
+
+	silenceId, err := result.LastInsertId()
+	if err != nil {
+		return silence, err
+	}
+
+	return GetSilence(silenceId)
+}
+
+func DeleteSilence(silenceId int64) error {
+	_, err := GetSilence(silenceId)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM silences where id = ?", silenceId)
+	return err
+}
+
+// isActive reports whether the silence mutes notifications at now. A
+// recurring window is active when its schedule ran within the last Minutes.
+func (s Silence) isActive(now time.Time) bool {
+	if s.Schedule == "" {
+		if s.StartsAt == nil || s.EndsAt == nil {
+			return false
+		}
+
+		start, err := parseSilenceTime(*s.StartsAt)
+		if err != nil {
+			return false
+		}
+
+		end, err := parseSilenceTime(*s.EndsAt)
+		if err != nil {
+			return false
+		}
+
+		return !now.Before(start) && now.Before(end)
+	}
+
+	schedule, err := ParseSchedule(s.Schedule)
+	if err != nil {
+		return false
+	}
+
+	// Silences created before the limit may be longer
+	minute := now.Truncate(time.Minute)
+	for i := int64(0); i < min(s.Minutes, maxSilenceMinutes); i++ {
+		if schedule.Matches(minute) {
+			return true
+		}
+		minute = minute.Add(-time.Minute)
+	}
+
+	return false
+}
+
+func (s Silence) matches(alert Alert) bool {
+	if s.Event != "" && s.Event != alert.Event {
+		return false
+	}
+
+	if s.AlertId != 0 && s.AlertId != alert.Id {
+		return false
+	}
+
+	return true
+}
+
+// IsAlertSilenced reports whether an active silence mutes the alert
+func IsAlertSilenced(alert Alert, now time.Time) (bool, error) {
+	silences, err := GetSilences()
+	if err != nil {
+		return false, err
+	}
+
+	for _, silence := range silences {
+		if silence.matches(alert) && silence.isActive(now) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// DeleteExpiredSilences removes one-off silences that ended before cutoff
+func DeleteExpiredSilences(cutoff time.Time) error {
+	_, err := db.Exec("DELETE FROM silences where schedule = '' and endsAt < ?", cutoff.Format("2006-01-02 15:04:05"))
+	return err
+}
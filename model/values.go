@@ -0,0 +1,205 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ValueStat is the aggregation of the values submitted in one bucket, e.g.
+// gauges and timers. Min, Max and Avg are null when no value was submitted.
+type ValueStat struct {
+	Time    int64    `json:"time"`
+	Count   int64    `json:"count"`
+	Samples int64    `json:"samples"`
+	Sum     float64  `json:"sum"`
+	Min     *float64 `json:"min"`
+	Max     *float64 `json:"max"`
+	Avg     *float64 `json:"avg"`
+}
+
+// migrateEventValues adds the value aggregation columns to the bucket
+// tables of events created before values were supported
+func migrateEventValues() error {
+	rows, err := db.Query("select event from events")
+	if err != nil {
+		return err
+	}
+
+	var events []string
+	for rows.Next() {
+		var event string
+		err = rows.Scan(&event)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		events = append(events, event)
+	}
+	rows.Close()
+
+	for _, event := range events {
+		for _, period := range eventPeriods {
+			table := fmt.Sprintf("%s_%s", period, event)
+
+			exists, err := columnExists(table, "samples")
+			if err != nil || exists {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			for _, column := range []string{
+				"samples INTEGER NOT NULL DEFAULT 0",
+				"sum REAL NOT NULL DEFAULT 0",
+				"min REAL",
+				"max REAL",
+			} {
+				_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, column))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// bucketStart returns the start of the daily, hourly or minutely bucket
+// containing t
+func bucketStart(period string, t time.Time) time.Time {
+	switch period {
+	case "daily":
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	case "hourly":
+		return t.Truncate(time.Hour)
+	}
+
+	return t.Truncate(time.Minute)
+}
+
+// SubmitEventSample adds count occurrences of event at t to every bucket,
+// value is aggregated into the samples, sum, min and max of the buckets
+// when it is given
+func SubmitEventSample(event string, count int64, value *float64, t time.Time) error {
+	var samples int64
+	var sum float64
+	if value != nil {
+		samples = 1
+		sum = *value
+	}
+
+	for _, period := range eventPeriods {
+		query := fmt.Sprintf(`
+			INSERT INTO %s_%s (time, count, samples, sum, min, max)
+			values (?, ?, ?, ?, ?, ?)
+			ON CONFLICT(time) DO UPDATE SET
+				count = count + excluded.count,
+				samples = samples + excluded.samples,
+				sum = sum + excluded.sum,
+				min = min(coalesce(min, excluded.min), coalesce(excluded.min, min)),
+				max = max(coalesce(max, excluded.max), coalesce(excluded.max, max))`,
+			period, event)
+
+		_, err := db.Exec(query, bucketStart(period, t).Unix(), count, samples, sum, value, value)
+		if err != nil {
+			CountDbError(err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Number of buckets kept for the periods that are pruned, see DeleteEvents
+var periodBuckets = map[string]int64{
+	"HOURLY":   60,
+	"MINUTELY": 60,
+}
+
+// Longest series of daily buckets, ten years
+const maxDailyBuckets = 3660
+
+// validateSeriesLength checks the number of buckets of a value or
+// dimension series, at most the buckets kept for the period
+func validateSeriesLength(period string, length int64) error {
+	limit, ok := periodBuckets[period]
+	if !ok {
+		limit = maxDailyBuckets
+	}
+
+	if length < 1 || length > limit {
+		return fmt.Errorf("Invalid length, must be between 1 and %d for %s", limit, period)
+	}
+
+	return nil
+}
+
+// GetEventValues returns the value aggregation of the last length buckets
+// of event, latest first
+func GetEventValues(event string, period string, length int64) ([]ValueStat, error) {
+	var valueStats []ValueStat
+
+	err := validateSeriesLength(period, length)
+	if err != nil {
+		return valueStats, err
+	}
+
+	var periodPrefix string
+	var step func(t time.Time, i int) time.Time
+
+	switch period {
+	case "DAILY":
+		periodPrefix = "daily"
+		step = func(t time.Time, i int) time.Time { return t.AddDate(0, 0, -i) }
+	case "HOURLY":
+		periodPrefix = "hourly"
+		step = func(t time.Time, i int) time.Time { return t.Add(-time.Duration(i) * time.Hour) }
+	case "MINUTELY":
+		periodPrefix = "minutely"
+		step = func(t time.Time, i int) time.Time { return t.Add(-time.Duration(i) * time.Minute) }
+	default:
+		return valueStats, errors.New("Invalid period")
+	}
+
+	startTime := bucketStart(periodPrefix, time.Now())
+	fromTime := step(startTime, int(length)-1)
+
+	query := fmt.Sprintf("select time, count, samples, sum, min, max from %s_%s where time between ? and ?", periodPrefix, event)
+	rows, err := db.Query(query, fromTime.Unix(), startTime.Unix())
+	if err != nil {
+		return valueStats, err
+	}
+	defer rows.Close()
+
+	valueMap := make(map[int64]ValueStat)
+	for rows.Next() {
+		var valueStat ValueStat
+		err = rows.Scan(&valueStat.Time, &valueStat.Count, &valueStat.Samples, &valueStat.Sum, &valueStat.Min, &valueStat.Max)
+		if err != nil {
+			return valueStats, err
+		}
+
+		if valueStat.Samples > 0 {
+			avg := valueStat.Sum / float64(valueStat.Samples)
+			valueStat.Avg = &avg
+		}
+
+		valueMap[valueStat.Time] = valueStat
+	}
+
+	for i := 0; i < int(length); i++ {
+		iTimestamp := step(startTime, i).Unix()
+
+		valueStat, ok := valueMap[iTimestamp]
+		if !ok {
+			valueStat = ValueStat{Time: iTimestamp}
+		}
+
+		valueStats = append(valueStats, valueStat)
+	}
+
+	return valueStats, nil
+}
@@ -0,0 +1,176 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"minim/model"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// smtpRootCAs verifies the certificate of the SMTP server after STARTTLS,
+// the system roots when nil
+var smtpRootCAs *x509.CertPool
+
+type smtpConfig struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+	startTLS bool
+}
+
+func getSmtpConfig() (smtpConfig, error) {
+	var config smtpConfig
+
+	config.host, _ = model.GetConfigValue("SMTP_HOST")
+	config.port, _ = model.GetConfigValue("SMTP_PORT")
+	config.username, _ = model.GetConfigValue("SMTP_USERNAME")
+	config.password, _ = model.GetConfigValue("SMTP_PASSWORD")
+	config.from, _ = model.GetConfigValue("SMTP_FROM")
+	startTLS, _ := model.GetConfigValue("SMTP_STARTTLS")
+	config.startTLS = startTLS == "1"
+
+	if config.host == "" {
+		return config, errors.New("SMTP_HOST is not configured")
+	}
+
+	if config.from == "" {
+		return config, errors.New("SMTP_FROM is not configured")
+	}
+
+	return config, nil
+}
+
+func boundary() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// buildMessage renders a plain text email, or a multipart/alternative one
+// when an HTML body is given
+func buildMessage(from string, to []string, subject string, text string, html string) []byte {
+	var msg bytes.Buffer
+
+	fmt.Fprintf(&msg, "From: %s\r\n", from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+
+	if html == "" {
+		msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+		msg.WriteString(text)
+		return msg.Bytes()
+	}
+
+	mark := boundary()
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", mark)
+	fmt.Fprintf(&msg, "--%s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n", mark, text)
+	fmt.Fprintf(&msg, "--%s\r\nContent-Type: text/html; charset=utf-8\r\n\r\n%s\r\n", mark, html)
+	fmt.Fprintf(&msg, "--%s--\r\n", mark)
+
+	return msg.Bytes()
+}
+
+func sendEmail(target string, subject string, text string, html string) error {
+	config, err := getSmtpConfig()
+	if err != nil {
+		return err
+	}
+
+	addresses, err := mail.ParseAddressList(target)
+	if err != nil {
+		return err
+	}
+
+	var to []string
+	for _, address := range addresses {
+		to = append(to, address.Address)
+	}
+
+	from, err := mail.ParseAddress(config.from)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(config.host, config.port), 10*time.Second)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(time.Minute))
+
+	client, err := smtp.NewClient(conn, config.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if config.startTLS {
+		ok, _ := client.Extension("STARTTLS")
+		if !ok {
+			return errors.New("SMTP server does not support STARTTLS")
+		}
+
+		err = client.StartTLS(&tls.Config{ServerName: config.host, RootCAs: smtpRootCAs})
+		if err != nil {
+			return err
+		}
+	}
+
+	if config.username != "" {
+		err = client.Auth(smtp.PlainAuth("", config.username, config.password, config.host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = client.Mail(from.Address)
+	if err != nil {
+		return err
+	}
+
+	for _, address := range to {
+		err = client.Rcpt(address)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	_, err = writer.Write(buildMessage(config.from, to, subject, text, html))
+	if err != nil {
+		return err
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func sendAlertEmail(channel model.Channel, notification Notification) error {
+	subject := fmt.Sprintf("[%s] %s", notification.State, notification.AlertName)
+	text := fmt.Sprintf("%s\r\n\r\nAlert: %s\r\nEvent: %s\r\nState: %s\r\nTime: %s\r\n",
+		notification.Message, notification.AlertName, notification.Event, notification.State, notification.Time)
+
+	return sendEmail(channel.Target, subject, text, "")
+}
@@ -0,0 +1,238 @@
+package notify
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"minim/model"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// sinkMessage is a message received by smtpSink
+type sinkMessage struct {
+	secured bool
+	auth    string
+	from    string
+	to      []string
+	data    string
+}
+
+// smtpSink is a local SMTP server keeping the messages it receives. It
+// offers STARTTLS when tlsConfig is set.
+type smtpSink struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+	messages  chan sinkMessage
+}
+
+func newSmtpSink(t *testing.T, tlsConfig *tls.Config) *smtpSink {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sink := &smtpSink{listener: listener, tlsConfig: tlsConfig, messages: make(chan sinkMessage, 10)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go sink.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+
+	return sink
+}
+
+func (s *smtpSink) port() string {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return port
+}
+
+func (s *smtpSink) serve(conn net.Conn) {
+	// conn is replaced by its TLS version after STARTTLS
+	defer func() { conn.Close() }()
+
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 sink ESMTP")
+
+	var message sinkMessage
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		command, argument, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(command) {
+		case "EHLO", "HELO":
+			text.PrintfLine("250-sink")
+			if s.tlsConfig != nil && !message.secured {
+				text.PrintfLine("250-STARTTLS")
+			}
+			text.PrintfLine("250 AUTH PLAIN")
+
+		case "STARTTLS":
+			text.PrintfLine("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn = tlsConn
+			text = textproto.NewConn(conn)
+			message.secured = true
+
+		case "AUTH":
+			credentials, _ := strings.CutPrefix(argument, "PLAIN ")
+			decoded, _ := base64.StdEncoding.DecodeString(credentials)
+			message.auth = string(decoded)
+			text.PrintfLine("235 Authenticated")
+
+		case "MAIL":
+			message.from = argument
+			text.PrintfLine("250 OK")
+
+		case "RCPT":
+			message.to = append(message.to, argument)
+			text.PrintfLine("250 OK")
+
+		case "DATA":
+			text.PrintfLine("354 Go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			message.data = string(data)
+			s.messages <- message
+			text.PrintfLine("250 OK")
+
+		case "QUIT":
+			text.PrintfLine("221 Bye")
+			return
+
+		default:
+			text.PrintfLine("502 Unknown command")
+		}
+	}
+}
+
+func setSmtpConfig(t *testing.T, values map[string]string) {
+	t.Helper()
+
+	for key, value := range values {
+		err := model.SetConfig(key, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// sinkTLSConfig returns the certificate of a test HTTPS server, valid for
+// 127.0.0.1, for the sink to present and for sendEmail to trust
+func sinkTLSConfig(t *testing.T) *tls.Config {
+	t.Helper()
+
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(server.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	smtpRootCAs = roots
+	t.Cleanup(func() { smtpRootCAs = nil })
+
+	return &tls.Config{Certificates: server.TLS.Certificates}
+}
+
+func TestSendEmailStartTLS(t *testing.T) {
+	sink := newSmtpSink(t, sinkTLSConfig(t))
+	setSmtpConfig(t, map[string]string{
+		"SMTP_HOST":     "127.0.0.1",
+		"SMTP_PORT":     sink.port(),
+		"SMTP_USERNAME": "minim",
+		"SMTP_PASSWORD": "p4ss",
+		"SMTP_FROM":     "Minimalytics <minim@example.com>",
+		"SMTP_STARTTLS": "1",
+	})
+
+	channel := model.Channel{Type: model.ChannelEmail, Target: "alice@example.com, bob@example.com"}
+	_, err := send(channel, testNotification())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := <-sink.messages
+	if !message.secured {
+		t.Fatal("message was sent before STARTTLS")
+	}
+
+	if message.auth != "\x00minim\x00p4ss" {
+		t.Fatalf("auth = %q", message.auth)
+	}
+
+	if message.from != "FROM:<minim@example.com>" || len(message.to) != 2 || message.to[1] != "TO:<bob@example.com>" {
+		t.Fatalf("envelope = %s to %v", message.from, message.to)
+	}
+
+	if !strings.Contains(message.data, "Subject: [FIRING] Signups \"daily\"") || !strings.Contains(message.data, "Signups dropped") {
+		t.Fatalf("data = %s", message.data)
+	}
+}
+
+func TestSendEmailPlain(t *testing.T) {
+	sink := newSmtpSink(t, nil)
+	setSmtpConfig(t, map[string]string{
+		"SMTP_HOST":     "127.0.0.1",
+		"SMTP_PORT":     sink.port(),
+		"SMTP_USERNAME": "",
+		"SMTP_PASSWORD": "",
+		"SMTP_FROM":     "minim@example.com",
+		"SMTP_STARTTLS": "0",
+	})
+
+	channel := model.Channel{Type: model.ChannelEmail, Target: "alice@example.com"}
+	_, err := send(channel, testNotification())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := <-sink.messages
+	if message.secured || message.auth != "" {
+		t.Fatalf("message secured = %v, auth = %q", message.secured, message.auth)
+	}
+
+	if len(message.to) != 1 || message.to[0] != "TO:<alice@example.com>" {
+		t.Fatalf("recipients = %v", message.to)
+	}
+}
+
+func TestSendEmailStartTLSUnsupported(t *testing.T) {
+	sink := newSmtpSink(t, nil)
+	setSmtpConfig(t, map[string]string{
+		"SMTP_HOST":     "127.0.0.1",
+		"SMTP_PORT":     sink.port(),
+		"SMTP_USERNAME": "",
+		"SMTP_FROM":     "minim@example.com",
+		"SMTP_STARTTLS": "1",
+	})
+
+	channel := model.Channel{Type: model.ChannelEmail, Target: "alice@example.com"}
+	_, err := send(channel, testNotification())
+	if err == nil || !strings.Contains(err.Error(), "STARTTLS") {
+		t.Fatalf("err = %v, expected a STARTTLS error", err)
+	}
+
+	select {
+	case message := <-sink.messages:
+		t.Fatalf("message sent without STARTTLS: %+v", message)
+	default:
+	}
+}
@@ -0,0 +1,169 @@
+package notify
+
+import (
+	"errors"
+	"log"
+	"minim/model"
+	"time"
+)
+
+// Notification is the data available to payload templates
+type Notification struct {
+	AlertId       int64   `json:"alertId"`
+	AlertName     string  `json:"alertName"`
+	Event         string  `json:"event"`
+	Type          string  `json:"type"`
+	PreviousState string  `json:"previousState"`
+	State         string  `json:"state"`
+	Value         float64 `json:"value"`
+	Message       string  `json:"message"`
+	Time          string  `json:"time"`
+}
+
+// Delivery attempts are retried with an exponential backoff starting at
+// retryDelay
+var maxAttempts = 5
+var retryDelay = 2 * time.Second
+
+// permanentError is a delivery failure that another attempt would not fix,
+// such as a payload template that does not render or a 4xx response
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// isNotifiable filters out the transitions nobody needs to hear about, e.g.
+// an alert going back from pending to resolved or a silenced alert. The
+// evaluation decides as it knows what receivers were last told.
+func isNotifiable(change model.AlertStateChange) bool {
+	return change.Notify
+}
+
+func newNotification(alert model.Alert, change model.AlertStateChange) Notification {
+	return Notification{
+		AlertId:       alert.Id,
+		AlertName:     alert.Name,
+		Event:         alert.Event,
+		Type:          alert.Type,
+		PreviousState: change.PreviousState,
+		State:         change.State,
+		Value:         change.Value,
+		Message:       change.Message,
+		Time:          change.CreatedOn,
+	}
+}
+
+// Dispatch sends the notifiable alert state changes to the channels of
+// their alert, every channel for alerts without channels. The deliveries run
+// in the background so a slow receiver does not hold up the evaluation of
+// alerts.
+func Dispatch(changes []model.AlertStateChange) {
+	var alerts []model.Alert
+	var notifications []Notification
+	for _, change := range changes {
+		if !isNotifiable(change) {
+			continue
+		}
+
+		alert, err := model.GetAlert(change.AlertId)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		alerts = append(alerts, alert)
+		notifications = append(notifications, newNotification(alert, change))
+	}
+
+	if len(notifications) == 0 {
+		return
+	}
+
+	channels, err := model.GetChannels()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for _, channel := range channels {
+		for i, notification := range notifications {
+			if alerts[i].NotifiesChannel(channel.Id) {
+				go deliver(channel, notification)
+			}
+		}
+	}
+}
+
+// Test sends a sample notification to a channel and waits for the result,
+// it is used to check a channel against a receiver
+func Test(channel model.Channel) error {
+	notification := Notification{
+		AlertName: "Test notification",
+		Event:     "test",
+		State:     model.AlertFiring,
+		Message:   "This is a test notification from Minimalytics",
+		Time:      time.Now().Format("2006-01-02 15:04:05"),
+	}
+
+	_, err := send(channel, notification)
+	return err
+}
+
+func send(channel model.Channel, notification Notification) (int, error) {
+	switch channel.Type {
+	case model.ChannelWebhook:
+		return sendWebhook(channel, notification)
+
+	case model.ChannelEmail:
+		return 0, sendAlertEmail(channel, notification)
+	}
+
+	return 0, nil
+}
+
+func deliver(channel model.Channel, notification Notification) {
+	delay := retryDelay
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		statusCode, err := send(channel, notification)
+
+		delivery := model.Delivery{
+			ChannelId:  channel.Id,
+			AlertId:    notification.AlertId,
+			Attempt:    int64(attempt),
+			Success:    err == nil,
+			StatusCode: int64(statusCode),
+		}
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+
+		recordErr := model.RecordDelivery(delivery)
+		if recordErr != nil {
+			log.Println(recordErr)
+		}
+
+		if err == nil {
+			return
+		}
+
+		log.Printf("Delivery to channel %d failed (attempt %d): %v", channel.Id, attempt, err)
+
+		var permanentErr *permanentError
+		if errors.As(err, &permanentErr) {
+			return
+		}
+
+		if attempt < maxAttempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+}
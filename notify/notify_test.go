@@ -0,0 +1,47 @@
+package notify
+
+import (
+	"fmt"
+	"minim/model"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestMain runs the tests against a database in a temporary home directory
+func TestMain(m *testing.M) {
+	home, err := os.MkdirTemp("", "minim-notify")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	os.Setenv("HOME", home)
+	err = os.MkdirAll(filepath.Join(home, ".minim"), 0700)
+	if err == nil {
+		err = model.Init()
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.RemoveAll(home)
+		os.Exit(1)
+	}
+
+	retryDelay = time.Millisecond
+
+	code := m.Run()
+	os.RemoveAll(home)
+	os.Exit(code)
+}
+
+func testNotification() Notification {
+	return Notification{
+		AlertId:   1,
+		AlertName: `Signups "daily"`,
+		Event:     "signup",
+		State:     model.AlertFiring,
+		Message:   "Signups dropped",
+		Time:      "2026-01-02 03:04:05",
+	}
+}
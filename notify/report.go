@@ -0,0 +1,163 @@
+package notify
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"minim/model"
+	"strings"
+	"time"
+)
+
+type ReportRow struct {
+	Graph    string  `json:"graph"`
+	Event    string  `json:"event"`
+	Period   string  `json:"period"`
+	Length   int64   `json:"length"`
+	Total    int64   `json:"total"`
+	Previous int64   `json:"previous"`
+	Change   float64 `json:"change"`
+}
+
+type RenderedReport struct {
+	Subject string      `json:"subject"`
+	Rows    []ReportRow `json:"rows"`
+	Text    string      `json:"text"`
+	Html    string      `json:"html"`
+}
+
+// reportRow sums a graph over its window and over the window before it.
+// Minutely and hourly buckets are trimmed, so the previous window of those
+// graphs can be incomplete.
+func reportRow(graph model.Graph) (ReportRow, error) {
+	row := ReportRow{
+		Graph:  graph.Name,
+		Event:  graph.Event,
+		Period: graph.Period,
+		Length: graph.Length,
+	}
+
+	if graph.Broken {
+		return row, nil
+	}
+
+	stats, err := model.GetEventData(graph.Event, graph.Period, graph.Length*2)
+	if err != nil {
+		return row, err
+	}
+
+	for i, stat := range stats {
+		if int64(i) < graph.Length {
+			row.Total += stat.Count
+		} else {
+			row.Previous += stat.Count
+		}
+	}
+
+	if row.Previous > 0 {
+		row.Change = float64(row.Total-row.Previous) / float64(row.Previous) * 100
+	}
+
+	return row, nil
+}
+
+func formatChange(row ReportRow) string {
+	if row.Previous == 0 {
+		return "n/a"
+	}
+
+	return fmt.Sprintf("%+.1f%%", row.Change)
+}
+
+func RenderReport(report model.Report) (RenderedReport, error) {
+	var rendered RenderedReport
+
+	dashboard, err := model.GetDashboard(report.DashboardId)
+	if err != nil {
+		return rendered, err
+	}
+
+	for _, graph := range dashboard.Graphs {
+		row, err := reportRow(graph)
+		if err != nil {
+			return rendered, err
+		}
+		rendered.Rows = append(rendered.Rows, row)
+	}
+
+	now := time.Now().Format("2006-01-02 15:04")
+	rendered.Subject = fmt.Sprintf("%s: %s", report.Name, dashboard.Name)
+
+	var text strings.Builder
+	fmt.Fprintf(&text, "%s\r\nDashboard: %s\r\nGenerated: %s\r\n\r\n", report.Name, dashboard.Name, now)
+	fmt.Fprintf(&text, "%-30s %-20s %12s %12s %10s\r\n", "Graph", "Window", "Total", "Previous", "Change")
+	for _, row := range rendered.Rows {
+		window := fmt.Sprintf("%d %s", row.Length, strings.ToLower(row.Period))
+		fmt.Fprintf(&text, "%-30s %-20s %12d %12d %10s\r\n", row.Graph, window, row.Total, row.Previous, formatChange(row))
+	}
+	rendered.Text = text.String()
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "<h2>%s</h2>\n<p>Dashboard: %s<br>Generated: %s</p>\n",
+		html.EscapeString(report.Name), html.EscapeString(dashboard.Name), now)
+	body.WriteString("<table border=\"1\" cellpadding=\"4\" cellspacing=\"0\">\n")
+	body.WriteString("<tr><th>Graph</th><th>Window</th><th>Total</th><th>Previous</th><th>Change</th></tr>\n")
+	for _, row := range rendered.Rows {
+		fmt.Fprintf(&body, "<tr><td>%s</td><td>%d %s</td><td align=\"right\">%d</td><td align=\"right\">%d</td><td align=\"right\">%s</td></tr>\n",
+			html.EscapeString(row.Graph), row.Length, strings.ToLower(row.Period), row.Total, row.Previous, formatChange(row))
+	}
+	body.WriteString("</table>\n")
+	rendered.Html = body.String()
+
+	return rendered, nil
+}
+
+func SendReport(report model.Report) error {
+	channel, err := model.GetChannel(report.ChannelId)
+	if err != nil {
+		return err
+	}
+
+	rendered, err := RenderReport(report)
+	if err != nil {
+		return err
+	}
+
+	return sendEmail(channel.Target, rendered.Subject, rendered.Text, rendered.Html)
+}
+
+// RunReports sends the reports whose schedule came up since their last run,
+// it is called every minute by the server
+func RunReports(now time.Time) {
+	reports, err := model.GetReports()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	for _, report := range reports {
+		due, err := model.IsReportDue(report, now)
+		if err != nil {
+			log.Printf("Unable to schedule report %d: %v", report.Id, err)
+			continue
+		}
+
+		if !due {
+			continue
+		}
+
+		// A failed report is not retried until its next scheduled run
+		err = model.SetReportLastRun(report.Id, now)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		go func(report model.Report) {
+			err := SendReport(report)
+			if err != nil {
+				log.Printf("Unable to send report %d: %v", report.Id, err)
+			}
+		}(report)
+	}
+}
@@ -0,0 +1,97 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"minim/model"
+	"net/http"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+var httpClient = &http.Client{
+	Timeout: 10 * time.Second,
+}
+
+type slackPayload struct {
+	Text string `json:"text"`
+}
+
+func webhookPayload(channel model.Channel, notification Notification) ([]byte, error) {
+	switch channel.Format {
+	case model.FormatSlack:
+		return json.Marshal(slackPayload{
+			Text: fmt.Sprintf("[%s] %s: %s", notification.State, notification.AlertName, notification.Message),
+		})
+
+	case model.FormatCustom:
+		payloadTemplate, err := template.New("payload").Funcs(model.PayloadTemplateFuncs).Parse(channel.Template)
+		if err != nil {
+			return nil, err
+		}
+
+		var payload bytes.Buffer
+		err = payloadTemplate.Execute(&payload, notification)
+		return payload.Bytes(), err
+	}
+
+	return json.Marshal(notification)
+}
+
+// sign returns the hex encoded HMAC-SHA256 of the timestamp, a dot and the
+// payload. Receivers verify it against the X-Minim-Signature header and
+// reject old timestamps so a captured delivery cannot be replayed.
+func sign(secret string, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func sendWebhook(channel model.Channel, notification Notification) (int, error) {
+	payload, err := webhookPayload(channel, notification)
+	if err != nil {
+		return 0, &permanentError{err}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, channel.Target, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "Minimalytics")
+	if channel.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("X-Minim-Timestamp", timestamp)
+		req.Header.Set("X-Minim-Signature", "sha256="+sign(channel.Secret, timestamp, payload))
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	err = fmt.Errorf("unexpected status %s", resp.Status)
+
+	// The receiver refused the payload, except for timeouts and rate limits
+	// sending it again would get the same answer
+	if resp.StatusCode >= 400 && resp.StatusCode <= 499 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests {
+		return resp.StatusCode, &permanentError{err}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, err
+	}
+
+	return resp.StatusCode, nil
+}
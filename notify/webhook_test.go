@@ -0,0 +1,164 @@
+package notify
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"minim/model"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func createWebhookChannel(t *testing.T, target string, format string, payloadTemplate string) model.Channel {
+	t.Helper()
+
+	channel, err := model.CreateChannel(model.ChannelCreate{
+		Name:     t.Name(),
+		Type:     model.ChannelWebhook,
+		Target:   target,
+		Secret:   "s3cret",
+		Format:   format,
+		Template: payloadTemplate,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return channel
+}
+
+func TestWebhookSignature(t *testing.T) {
+	var body []byte
+	var timestamp, signature string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		timestamp = r.Header.Get("X-Minim-Timestamp")
+		signature = r.Header.Get("X-Minim-Signature")
+	}))
+	defer server.Close()
+
+	channel := createWebhookChannel(t, server.URL, model.FormatGeneric, "")
+	statusCode, err := sendWebhook(channel, testNotification())
+	if err != nil || statusCode != http.StatusOK {
+		t.Fatalf("sendWebhook = %d, %v", statusCode, err)
+	}
+
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || time.Since(time.Unix(sent, 0)) > time.Minute {
+		t.Fatalf("invalid timestamp %q", timestamp)
+	}
+
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(timestamp + "." + string(body)))
+	expected := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if signature != expected {
+		t.Fatalf("signature = %q, expected %q", signature, expected)
+	}
+
+	var notification Notification
+	err = json.Unmarshal(body, &notification)
+	if err != nil || notification != testNotification() {
+		t.Fatalf("payload = %s, %v", body, err)
+	}
+}
+
+func TestWebhookCustomTemplate(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	channel := createWebhookChannel(t, server.URL, model.FormatCustom, `{"text": {{json .AlertName}}}`)
+	_, err := sendWebhook(channel, testNotification())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var payload struct {
+		Text string `json:"text"`
+	}
+	err = json.Unmarshal(body, &payload)
+	if err != nil || payload.Text != testNotification().AlertName {
+		t.Fatalf("payload = %s, %v", body, err)
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		attempts int64
+		success  bool
+	}{
+		{"success", []int{200}, 1, true},
+		{"server errors", []int{500, 503, 200}, 3, true},
+		{"rate limited", []int{429, 200}, 2, true},
+		{"client error", []int{400}, 1, false},
+		{"not found", []int{404}, 1, false},
+		{"always failing", []int{500}, int64(maxAttempts), false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var requests atomic.Int64
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := int(requests.Add(1)) - 1
+				w.WriteHeader(test.statuses[min(n, len(test.statuses)-1)])
+			}))
+			defer server.Close()
+
+			channel := createWebhookChannel(t, server.URL, model.FormatGeneric, "")
+			deliver(channel, testNotification())
+
+			if requests.Load() != test.attempts {
+				t.Fatalf("%d requests, expected %d", requests.Load(), test.attempts)
+			}
+
+			deliveries, err := model.GetDeliveries(channel.Id)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if int64(len(deliveries)) != test.attempts {
+				t.Fatalf("%d deliveries recorded, expected %d", len(deliveries), test.attempts)
+			}
+
+			// Deliveries are listed latest first
+			if deliveries[0].Success != test.success {
+				t.Fatalf("last delivery success = %v, expected %v", deliveries[0].Success, test.success)
+			}
+		})
+	}
+}
+
+func TestDeliverTemplateError(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer server.Close()
+
+	// Parses but cannot be executed, Message has no fields
+	channel := createWebhookChannel(t, server.URL, model.FormatCustom, `{"text": {{.Message.Text}}}`)
+	deliver(channel, testNotification())
+
+	if requests.Load() != 0 {
+		t.Fatalf("%d requests, expected none", requests.Load())
+	}
+
+	deliveries, err := model.GetDeliveries(channel.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(deliveries) != 1 || deliveries[0].Success {
+		t.Fatalf("deliveries = %+v, expected a single failed attempt", deliveries)
+	}
+}